@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/zeozeozeo/gomodplay/pkg/spectrum"
+)
+
+var spectrumPeakColour = tcell.GetColor("#F879C0")
+
+// spectrumLabels are the frequencies marked under the analyser
+var spectrumLabels = []struct {
+	frequency float64
+	label     string
+}{
+	{50, "50"}, {100, "100"}, {200, "200"}, {500, "500"},
+	{1000, "1k"}, {2000, "2k"}, {5000, "5k"}, {10000, "10k"},
+}
+
+// drawSpectrum draws a bar for each of the analyser's bands in b with its
+// held peak over it, and marks frequencies along the bottom border
+func drawSpectrum(s tcell.Screen, analyser *spectrum.Analyser, b box) {
+	drawBox(s, b.x1, b.y1, b.x2, b.y2)
+	rows := b.innerHeight()
+	bottom := b.y2 - 1
+
+	runes := []rune{'▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'}
+	lowStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(meterColour2)
+	highStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(meterColour1)
+	peakStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(spectrumPeakColour)
+
+	// height returns how many eighths of a row a level fills
+	height := func(level float64) int {
+		return int((level - spectrum.Floor) / -spectrum.Floor * float64(rows*8))
+	}
+
+	levels, peaks := analyser.Levels(), analyser.Peaks()
+	for band := range levels {
+		x := b.x1 + 1 + band
+		if x >= b.x2 {
+			break
+		}
+		eighths := height(levels[band])
+		for row := 0; row < rows && eighths > row*8; row++ {
+			style := lowStyle
+			if row >= rows*2/3 {
+				style = highStyle
+			}
+			fill := eighths - row*8
+			if fill > 8 {
+				fill = 8
+			}
+			s.SetContent(x, bottom-row, runes[fill-1], nil, style)
+		}
+
+		peakRow := height(peaks[band]) / 8
+		if peakRow < rows && peakRow*8 >= eighths && peaks[band] > spectrum.Floor {
+			s.SetContent(x, bottom-peakRow, '─', nil, peakStyle)
+		}
+	}
+
+	borderStyle := tcell.StyleDefault.Background(boxBgColour).Foreground(boxFgColour)
+	next := b.x1 + 1
+	for _, mark := range spectrumLabels {
+		for band := 0; band < analyser.NumBands(); band++ {
+			if analyser.BandFrequency(band+1) <= mark.frequency {
+				continue
+			}
+			x := b.x1 + 1 + band
+			if x >= next && x+len(mark.label) < b.x2 {
+				drawText(s, x, b.y2, len(mark.label), 1, borderStyle, mark.label)
+				next = x + len(mark.label) + 1
+			}
+			break
+		}
+	}
+}
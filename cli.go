@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeozeozeo/gomodplay/pkg/mod"
+	"github.com/zeozeozeo/gomodplay/pkg/playlist"
+	"github.com/zeozeozeo/gomodplay/pkg/speaker"
+)
+
+const usage = `Usage: gomodplay [command] [flags] [file...]
+
+Commands:
+  play    play modules, directories and playlists, or pick them in a browser (default)
+  render  render the files to a WAV file
+  info    print information about the files
+
+Run "gomodplay <command> -h" for the flags of a command.
+`
+
+var commands = map[string]func(args []string){
+	"play":   playCommand,
+	"render": renderCommand,
+	"info":   infoCommand,
+}
+
+func main() {
+	args := os.Args[1:]
+	command := playCommand
+	if len(args) > 0 {
+		if named, ok := commands[args[0]]; ok {
+			command = named
+			args = args[1:]
+		} else if args[0] == "help" {
+			fmt.Fprint(os.Stderr, usage)
+			return
+		}
+	}
+	command(args)
+}
+
+// newFlagSet returns a flag set whose usage lists the commands first
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		fmt.Fprintf(flags.Output(), "\nFlags of %s:\n", name)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+var mixingModes = map[string]mod.MixingMode{
+	"amiga":  mod.AmigaMixingMode,
+	"stereo": mod.StereoMixingMode,
+	"mono":   mod.MonoMixingMode,
+}
+
+var interpolations = map[string]mod.Interpolation{
+	"none":   mod.NoInterpolation,
+	"linear": mod.LinearInterpolation,
+}
+
+var repeatModes = map[string]playlist.RepeatMode{
+	"off": playlist.RepeatOff,
+	"one": playlist.RepeatOne,
+	"all": playlist.RepeatAll,
+}
+
+var sampleFormats = map[string]speaker.SampleFormat{
+	"int16":   speaker.Int16,
+	"int24":   speaker.Int24,
+	"float32": speaker.Float32,
+}
+
+// playerFlags are the playback options shared by all commands
+type playerFlags struct {
+	rate          *uint
+	mix           *string
+	standard      *string
+	loop          *int
+	interpolation *string
+	ramp          *time.Duration
+	limiter       *bool
+	headroom      *bool
+}
+
+func addPlayerFlags(flags *flag.FlagSet) *playerFlags {
+	return &playerFlags{
+		rate:          flags.Uint("rate", 48000, "output sample rate in Hz"),
+		mix:           flags.String("mix", "stereo", "mixing mode: amiga, stereo, mono or a stereo separation of 0-200 percent"),
+		standard:      flags.String("standard", "auto", "timing standard: auto, pal or ntsc"),
+		loop:          flags.Int("loop", 0, "number of times to play each song again, -1 for forever"),
+		interpolation: flags.String("interp", "none", "sample interpolation: none or linear"),
+		ramp:          flags.Duration("ramp", 0, "time over which volume and pan changes are smoothed, such as 1ms, 0 for none"),
+		limiter:       flags.Bool("limiter", false, "soft limit peaks, starting 4 dB below full scale and staying under -1 dBFS"),
+		headroom:      flags.Bool("headroom", false, "lower the gain of modules with more than 4 channels"),
+	}
+}
+
+// settings returns the player settings and sample rate picked by the flags
+func (f *playerFlags) settings() (mod.Settings, uint32, error) {
+	if *f.rate < 8000 || *f.rate > 192000 {
+		return mod.Settings{}, 0, fmt.Errorf("sample rate %d is outside 8000-192000 Hz", *f.rate)
+	}
+	sampleRate := uint32(*f.rate)
+	settings := mod.NewModPlayer(sampleRate).Settings()
+
+	if mode, ok := mixingModes[strings.ToLower(*f.mix)]; ok {
+		settings.MixingMode = mode
+		settings.StereoSeparation = mode.Separation()
+	} else if separation, err := strconv.ParseUint(*f.mix, 10, 32); err == nil && separation <= 200 {
+		settings.MixingMode = mod.CustomMixingMode
+		settings.StereoSeparation = uint32(separation)
+	} else {
+		return mod.Settings{}, 0, fmt.Errorf("unknown mixing mode %q", *f.mix)
+	}
+
+	switch strings.ToLower(*f.standard) {
+	case "auto":
+		settings.AutoStandard = true
+	case "pal":
+		settings.AutoStandard = false
+		settings.Standard = mod.PAL
+	case "ntsc":
+		settings.AutoStandard = false
+		settings.Standard = mod.NTSC
+	default:
+		return mod.Settings{}, 0, fmt.Errorf("unknown standard %q", *f.standard)
+	}
+
+	if *f.loop < mod.LoopForever {
+		return mod.Settings{}, 0, fmt.Errorf("loop count %d is below -1", *f.loop)
+	}
+	settings.LoopCount = *f.loop
+
+	interpolation, ok := interpolations[strings.ToLower(*f.interpolation)]
+	if !ok {
+		return mod.Settings{}, 0, fmt.Errorf("unknown interpolation %q", *f.interpolation)
+	}
+	settings.Interpolation = interpolation
+
+	if *f.ramp < 0 {
+		return mod.Settings{}, 0, fmt.Errorf("volume ramp %v is negative", *f.ramp)
+	}
+	settings.VolumeRamp = uint32(f.ramp.Seconds() * float64(sampleRate))
+	settings.Limiter = *f.limiter
+	settings.AutoHeadroom = *f.headroom
+	return settings, sampleRate, nil
+}
+
+// formatFlags pick the sample format and channels written to the output
+type formatFlags struct {
+	sampleFormat *string
+	channels     *int
+	dither       *bool
+}
+
+func addFormatFlags(flags *flag.FlagSet) *formatFlags {
+	return &formatFlags{
+		sampleFormat: flags.String("format", "int16", "output sample format: int16, int24 or float32"),
+		channels:     flags.Int("channels", 2, "output channels: 1 for mono, 2 for stereo"),
+		dither:       flags.Bool("dither", false, "add TPDF dither when converting to integer samples"),
+	}
+}
+
+func (f *formatFlags) format(sampleRate uint32) (speaker.Format, error) {
+	sampleFormat, ok := sampleFormats[*f.sampleFormat]
+	if !ok {
+		return speaker.Format{}, fmt.Errorf("unknown sample format %q", *f.sampleFormat)
+	}
+	return speaker.Format{
+		SampleRate:   sampleRate,
+		NumChannels:  *f.channels,
+		SampleFormat: sampleFormat,
+	}, nil
+}
+
+// expandInputs replaces directories with the modules inside them and
+// playlists with their entries
+func expandInputs(inputs []string) []string {
+	var paths []string
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		switch {
+		case err == nil && info.IsDir():
+			filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && isModule(info.Name()) {
+					paths = append(paths, path)
+				}
+				return nil
+			})
+		case playlist.IsPlaylist(input):
+			entries, err := playlist.Load(input)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			paths = append(paths, entries...)
+		default:
+			paths = append(paths, input)
+		}
+	}
+	return paths
+}
+
+// newBackend returns the audio output selected on the command line
+func newBackend(name string, path string) (speaker.Backend, error) {
+	switch name {
+	case "oto":
+		return speaker.NewOtoBackend(), nil
+	case "wav":
+		if path == "" {
+			path = "out.wav"
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return speaker.NewWAVBackend(f), nil
+	case "pcm":
+		if path == "" || path == "-" {
+			return speaker.NewPCMBackend(os.Stdout), nil
+		}
+		b, err := speaker.CreatePCMBackend(path)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "null":
+		return speaker.NewNullBackend(), nil
+	}
+	return nil, fmt.Errorf("unknown output %q", name)
+}
+
+func playCommand(args []string) {
+	flags := newFlagSet("play")
+	playerOptions := addPlayerFlags(flags)
+	formatOptions := addFormatFlags(flags)
+	output := flags.String("output", "oto", "audio output: oto, wav, pcm or null")
+	outputPath := flags.String("o", "", "file written by the wav (default out.wav) and pcm (default stdout) outputs")
+	latency := flags.Duration("latency", 10*time.Millisecond, "length of the output buffer, lower for less delay")
+	crossfade := flags.Duration("crossfade", 0, "crossfade between songs, 0 for a gapless switch")
+	noUI := flags.Bool("no-ui", false, "play without the tracker view, printing progress lines")
+	shuffle := flags.Bool("shuffle", false, "play the files in a random order")
+	repeatName := flags.String("repeat", "off", "repeat the queue: off, one or all")
+	flags.Parse(args)
+
+	settings, sampleRate, err := playerOptions.settings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	format, err := formatOptions.format(sampleRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	repeat, ok := repeatModes[strings.ToLower(*repeatName)]
+	if !ok {
+		log.Fatalf("unknown repeat mode %q", *repeatName)
+	}
+	queue := playlist.NewQueue()
+	queue.SetShuffle(*shuffle)
+	queue.SetRepeat(repeat)
+	queue.Add(expandInputs(flags.Args())...)
+	if *noUI && queue.Len() == 0 {
+		log.Fatal("no files to play")
+	}
+
+	backend, err := newBackend(*output, *outputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bufferSize := uint32(latency.Seconds() * float64(sampleRate))
+	if bufferSize == 0 {
+		bufferSize = 1
+	}
+	sp, err := speaker.New(backend, format, bufferSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sp.SetDither(*formatOptions.dither)
+
+	if *noUI {
+		runHeadless(sp, sampleRate, settings, *crossfade, queue)
+	} else {
+		runUI(sp, sampleRate, settings, *crossfade, queue)
+	}
+}
+
+func renderCommand(args []string) {
+	flags := newFlagSet("render")
+	playerOptions := addPlayerFlags(flags)
+	formatOptions := addFormatFlags(flags)
+	outputPath := flags.String("o", "out.wav", "WAV file to write")
+	crossfade := flags.Duration("crossfade", 0, "crossfade between songs, 0 for a gapless switch")
+	flags.Parse(args)
+
+	settings, sampleRate, err := playerOptions.settings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if settings.LoopCount == mod.LoopForever {
+		log.Fatal("can't render a song that loops forever")
+	}
+	format, err := formatOptions.format(sampleRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue := playlist.NewQueue()
+	queue.Add(expandInputs(flags.Args())...)
+	if queue.Len() == 0 {
+		log.Fatal("no files to render")
+	}
+
+	var nowPlaying currentPlayer
+	songs := newPlaylist(&nowPlaying, queue, sampleRate, settings, *crossfade, nil)
+	if songs == nil {
+		log.Fatal("none of the files could be rendered")
+	}
+
+	f, err := os.Create(*outputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sp, err := speaker.New(speaker.NewWAVBackend(f), format, 4096)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sp.SetDither(*formatOptions.dither)
+
+	done := make(chan bool, 1)
+	sp.Play(songs, func() {
+		done <- true
+	})
+	<-done
+
+	frames := sp.FramesPlayed()
+	if err := sp.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := sp.Err(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Rendered %s to %s\n", formatTime(time.Duration(frames)*time.Second/time.Duration(sampleRate)), *outputPath)
+}
+
+func infoCommand(args []string) {
+	flags := newFlagSet("info")
+	playerOptions := addPlayerFlags(flags)
+	asJSON := flags.Bool("json", false, "print one JSON object per file")
+	flags.Parse(args)
+
+	settings, sampleRate, err := playerOptions.settings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		log.Fatal("no files to describe")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	failed := false
+	for _, path := range expandInputs(flags.Args()) {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		player, err := openPlayer(f, sampleRate, settings)
+		f.Close()
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			failed = true
+			continue
+		}
+
+		metadata := player.Metadata()
+		if *asJSON {
+			err = encoder.Encode(struct {
+				Path string `json:"path"`
+				mod.Metadata
+			}{path, metadata})
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			printInfo(path, metadata)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printInfo(path string, metadata mod.Metadata) {
+	usedSamples := 0
+	for _, sample := range metadata.Samples {
+		if sample.Size > 0 {
+			usedSamples++
+		}
+	}
+	fmt.Println(path)
+	fmt.Printf("  Title:     %s\n", metadata.Title)
+	fmt.Printf("  Format:    %s, %d channels\n", metadata.Format, metadata.Channels)
+	fmt.Printf("  Orders:    %d\n", len(metadata.Orders))
+	fmt.Printf("  Patterns:  %d\n", metadata.Patterns)
+	fmt.Printf("  Samples:   %d\n", usedSamples)
+	fmt.Printf("  Effects:   %s\n", strings.Join(metadata.Effects, " "))
+	fmt.Printf("  Duration:  %s\n", formatTime(seconds(metadata.Duration)))
+	for _, subsong := range metadata.Subsongs {
+		fmt.Printf("  Subsong:   order %d, %s\n", subsong.Order, formatTime(seconds(subsong.Duration)))
+	}
+}
+
+// runHeadless plays files without a screen, printing a line per song and
+// a progress line every second to stderr, keeping stdout free for the pcm
+// output
+func runHeadless(sp *speaker.Speaker, sampleRate uint32, settings mod.Settings, crossfade time.Duration, queue *playlist.Queue) {
+	var nowPlaying currentPlayer
+	songs := newPlaylist(&nowPlaying, queue, sampleRate, settings, crossfade, recordPlay(openStore()))
+	if songs == nil {
+		log.Fatal("none of the files could be played")
+	}
+
+	done := make(chan bool, 1)
+	sp.Play(songs, func() {
+		done <- true
+	})
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var heard *mod.Player
+	for {
+		select {
+		case <-done:
+			if err := sp.Close(); err != nil {
+				log.Fatal(err)
+			}
+			if err := sp.Err(); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case <-ticker.C:
+			player := nowPlaying.get()
+			if player != heard {
+				heard = player
+				fmt.Fprintf(os.Stderr, "Playing %q (%s, %s)\n", songTitle(player.Song), player.Song.Format.Tag, formatTime(player.Duration()))
+			}
+			snapshot := heardSnapshot(player, sp, sampleRate)
+			// The time heard lags the mixer by the speaker's latency
+			elapsed := player.Elapsed() - sp.Latency()
+			if elapsed < 0 {
+				elapsed = 0
+			}
+			fmt.Fprintf(os.Stderr, "%s / %s  order %d/%d  pattern %d  row %d\n",
+				formatTime(elapsed), formatTime(player.Duration()),
+				snapshot.Order, snapshot.Song.NumUsedPatterns, snapshot.Pattern, snapshot.Row)
+		}
+	}
+}
+
+// songTitle returns the song name without its zero padding
+func songTitle(song *mod.Song) string {
+	return strings.TrimSpace(strings.TrimRight(song.Name, "\x00"))
+}
+
+// seconds converts a duration in seconds from the metadata
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// formatTime formats a duration as minutes and seconds
+func formatTime(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/zeozeozeo/gomodplay/pkg/mod"
+	"github.com/zeozeozeo/gomodplay/pkg/library"
+	"github.com/zeozeozeo/gomodplay/pkg/playlist"
+	"github.com/zeozeozeo/gomodplay/pkg/userdata"
 
 	"github.com/gdamore/tcell/v2"
 )
@@ -18,11 +23,25 @@ var fileStyle = tcell.StyleDefault.Background(sampleBgColour).Foreground(sampleF
 var fileHighlightStyle = tcell.StyleDefault.Background(sampleHighlightBgColour).Foreground(sampleHighlightFgColour).Bold(true)
 var modRegexp = regexp.MustCompile("(?i).mod")
 
+// browserHelpWidth is the narrowest screen the key help is shown on
+const browserHelpWidth = 110
+
+// viewRefresh is how often the view picks up metadata the library found
+const viewRefresh = time.Second / 4
+
 type file struct {
 	name       string
+	path       string
 	isDir      bool
+	isPlaylist bool
 	size       int64
-	moduleName *string
+	// Filled in from the library once it has scanned the file
+	scanned  bool
+	title    string
+	format   string
+	duration float64
+	// Filled in from the user's favourites and history
+	record userdata.Record
 }
 
 func parseDir(path string) ([]file, error) {
@@ -31,6 +50,7 @@ func parseDir(path string) ([]file, error) {
 	if path != "/" {
 		parentDir := file{
 			name:  "../",
+			path:  filepath.Dir(path),
 			isDir: true,
 		}
 
@@ -46,6 +66,7 @@ func parseDir(path string) ([]file, error) {
 		if f.IsDir() {
 			dir := file{
 				name:  fmt.Sprintf("%s/", f.Name()),
+				path:  filepath.Join(path, f.Name()),
 				size:  f.Size(),
 				isDir: true,
 			}
@@ -53,12 +74,13 @@ func parseDir(path string) ([]file, error) {
 			continue
 		}
 		name := f.Name()
-		matched := modRegexp.MatchString(name)
-		if matched && name != "go.mod" {
+		if isModule(name) || playlist.IsPlaylist(name) {
 			mod := file{
-				name:  name,
-				size:  f.Size(),
-				isDir: false,
+				name:       name,
+				path:       filepath.Join(path, name),
+				size:       f.Size(),
+				isDir:      false,
+				isPlaylist: playlist.IsPlaylist(name),
 			}
 			matchingFiles = append(matchingFiles, mod)
 		}
@@ -67,6 +89,11 @@ func parseDir(path string) ([]file, error) {
 	return matchingFiles, nil
 }
 
+// isModule tells whether a file name looks like a module
+func isModule(name string) bool {
+	return modRegexp.MatchString(name) && name != "go.mod" && !playlist.IsPlaylist(name)
+}
+
 func changeDir(s tcell.Screen, dir string) ([]file, error) {
 	dir, err := filepath.Abs(fmt.Sprintf("%s/%s", currentState.currentDir, dir))
 	if err != nil {
@@ -74,18 +101,241 @@ func changeDir(s tcell.Screen, dir string) ([]file, error) {
 	}
 	currentState.currentDir = dir
 	currentState.currentIdx = 0
+	currentState.scroll = 0
+	currentState.query = ""
 	return parseDir(currentState.currentDir)
 }
 
+// viewModules returns the paths of the modules in the view and the index of
+// the selected one among them
+func viewModules() ([]string, int) {
+	var paths []string
+	selected := 0
+	for idx, entry := range currentState.view {
+		if entry.isDir || entry.isPlaylist {
+			continue
+		}
+		if idx == currentState.currentIdx {
+			selected = len(paths)
+		}
+		paths = append(paths, entry.path)
+	}
+	return paths, selected
+}
+
+// storedFiles lists modules the store knows about, wherever they are
+func storedFiles(paths []string) []file {
+	files := make([]file, 0, len(paths))
+	for _, path := range paths {
+		entry := file{
+			name: filepath.Base(path),
+			path: path,
+		}
+		if info, err := os.Stat(path); err == nil {
+			entry.size = info.Size()
+		}
+		files = append(files, entry)
+	}
+	return files
+}
+
+// viewMode picks which modules the browser lists
+type viewMode int
+
+const (
+	// directoryView lists the current directory
+	directoryView viewMode = iota
+	// favouritesView lists the favourite modules from every directory
+	favouritesView
+	// recentView lists the modules played most recently
+	recentView
+)
+
+func (mode viewMode) String() string {
+	return [...]string{"Directory", "Favourites", "Recent"}[mode]
+}
+
+// recentLimit is the number of modules the recent view lists
+const recentLimit = 100
+
+// listEntries reads the entries of the current view mode
+func (st *state) listEntries(store *userdata.Store) ([]file, error) {
+	switch st.mode {
+	case favouritesView:
+		return storedFiles(store.Favourites()), nil
+	case recentView:
+		return storedFiles(store.Recent(recentLimit)), nil
+	}
+	return parseDir(st.currentDir)
+}
+
+// sortKey is the column the browser is sorted by
+type sortKey int
+
+const (
+	sortByName sortKey = iota
+	sortBySize
+	sortByTitle
+	sortByFormat
+	sortByDuration
+	// The keys below sort the highest or newest first
+	sortByRating
+	sortByPlays
+	sortByLastPlayed
+)
+
+func (key sortKey) String() string {
+	return [...]string{"Name", "Size", "Title", "Format", "Duration", "Rating", "Plays", "Last played"}[key]
+}
+
 type state struct {
+	mu         sync.Mutex
 	currentDir string
+	// currentIdx and scroll index into view, the entries that match the
+	// search in the order they are sorted
 	currentIdx int
+	scroll     int
 	entries    []file
+	view       []file
+	query      string
+	searching  bool
+	sortBy     sortKey
+	reverse    bool
+	mode       viewMode
+	status     string
+	// rows is the number of entries that fit in the box, set as the
+	// screen is drawn
+	rows int
 }
 
 var currentState *state
 
-func load() *os.File {
+// refreshView filters and sorts the entries into the view, keeping the
+// selected entry selected
+func (st *state) refreshView(lib *library.Library, store *userdata.Store) {
+	selected := ""
+	if st.currentIdx >= 0 && st.currentIdx < len(st.view) {
+		selected = st.view[st.currentIdx].name
+	}
+
+	query := strings.ToLower(st.query)
+	view := make([]file, 0, len(st.entries))
+	for _, entry := range st.entries {
+		if !entry.isDir && !entry.isPlaylist {
+			entry.record = store.Get(entry.path)
+			if info, ok := lib.Lookup(entry.path); ok {
+				entry.scanned = true
+				entry.title = info.Title
+				entry.format = info.Format
+				entry.duration = info.Duration
+				if info.Err != "" {
+					entry.title = "<unreadable>"
+				}
+			}
+		}
+		if entry.name == "../" || query == "" ||
+			strings.Contains(strings.ToLower(entry.name), query) ||
+			strings.Contains(strings.ToLower(entry.title), query) {
+			view = append(view, entry)
+		}
+	}
+
+	sort.SliceStable(view, func(i, j int) bool {
+		a, b := view[i], view[j]
+		if a.isDir != b.isDir || a.isDir {
+			if a.name == "../" || b.name == "../" {
+				return a.name == "../"
+			}
+			return a.isDir && (!b.isDir || a.name < b.name)
+		}
+		if st.reverse {
+			a, b = b, a
+		}
+		return lessFile(a, b, st.sortBy)
+	})
+	st.view = view
+
+	st.currentIdx = 0
+	for idx, entry := range view {
+		if entry.name == selected {
+			st.currentIdx = idx
+			break
+		}
+	}
+}
+
+// lessFile orders two files by key, falling back to their names. Files
+// the library hasn't scanned yet go after those it has
+func lessFile(a, b file, key sortKey) bool {
+	if key >= sortByTitle && a.scanned != b.scanned {
+		return a.scanned
+	}
+	switch key {
+	case sortBySize:
+		if a.size != b.size {
+			return a.size < b.size
+		}
+	case sortByTitle:
+		if at, bt := strings.ToLower(a.title), strings.ToLower(b.title); at != bt {
+			return at < bt
+		}
+	case sortByFormat:
+		if a.format != b.format {
+			return a.format < b.format
+		}
+	case sortByDuration:
+		if a.duration != b.duration {
+			return a.duration < b.duration
+		}
+	case sortByRating:
+		if a.record.Rating != b.record.Rating {
+			return a.record.Rating > b.record.Rating
+		}
+	case sortByPlays:
+		if a.record.PlayCount != b.record.PlayCount {
+			return a.record.PlayCount > b.record.PlayCount
+		}
+	case sortByLastPlayed:
+		if !a.record.LastPlayed.Equal(b.record.LastPlayed) {
+			return a.record.LastPlayed.After(b.record.LastPlayed)
+		}
+	}
+	return strings.ToLower(a.name) < strings.ToLower(b.name)
+}
+
+// scrollToSelected scrolls the view just enough to show the selected entry
+func (st *state) scrollToSelected() {
+	if st.currentIdx >= len(st.view) {
+		st.currentIdx = len(st.view) - 1
+	}
+	if st.currentIdx < 0 {
+		st.currentIdx = 0
+	}
+	if st.currentIdx < st.scroll {
+		st.scroll = st.currentIdx
+	}
+	if st.currentIdx >= st.scroll+st.rows {
+		st.scroll = st.currentIdx - st.rows + 1
+	}
+}
+
+// unusedPath returns a path in dir for a new file called name with the
+// extension ext, numbering it to keep clear of files that already exist
+func unusedPath(dir, name, ext string) string {
+	path := filepath.Join(dir, name+ext)
+	for n := 2; ; n++ {
+		// Other errors are left to whatever writes the file
+		if _, err := os.Lstat(path); err != nil {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, n, ext))
+	}
+}
+
+// load runs the file browser. Picking a module or playlist fills the queue
+// from it and returns true, other files can be added to the queue as it is.
+// It returns false when the browser is left with Escape
+func load(queue *playlist.Queue, lib *library.Library, store *userdata.Store) bool {
 	s, e := tcell.NewScreen()
 	defer s.Fini()
 	if e != nil {
@@ -96,6 +346,10 @@ func load() *os.File {
 		fmt.Fprintf(os.Stderr, "%v\n", e)
 		os.Exit(1)
 	}
+	// Warnings from scanning modules would be logged over the browser
+	output := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(output)
 
 	defStyle := tcell.StyleDefault.
 		Background(tcell.ColorBlack).
@@ -103,36 +357,63 @@ func load() *os.File {
 	s.SetStyle(defStyle)
 	s.Clear()
 
-	// Event loop
-	quit := func() {
-		s.Fini()
-		os.Exit(0)
-	}
-
-	currentState = &state{
-		currentDir: "./modfiles",
+	if currentState == nil {
+		dir, err := filepath.Abs("./modfiles")
+		if err != nil {
+			panic(err)
+		}
+		currentState = &state{
+			currentDir: dir,
+			rows:       1,
+		}
 	}
+	lib.Scan(currentState.currentDir)
 
-	dirEntries, err := parseDir(currentState.currentDir)
+	currentState.mu.Lock()
+	currentState.status = ""
+	dirEntries, err := currentState.listEntries(store)
 	if err != nil {
 		panic(err)
 	}
 	currentState.entries = dirEntries
+	currentState.refreshView(lib, store)
+	currentState.mu.Unlock()
 
 	terminate := make(chan bool)
 
 	go func(currentState *state) {
-		var m sync.Mutex
-
+		lastRefresh := time.Now()
+		var lastWidth, lastHeight int
 		for {
 			select {
 			case <-terminate:
 				return
 			default:
+				currentState.mu.Lock()
+				if time.Since(lastRefresh) >= viewRefresh {
+					currentState.refreshView(lib, store)
+					lastRefresh = time.Now()
+				}
+				width, height := s.Size()
+				if width != lastWidth || height != lastHeight {
+					lastWidth, lastHeight = width, height
+					s.Clear()
+				}
+				currentState.rows = height - 3
+				if currentState.rows < 1 {
+					currentState.rows = 1
+				}
+				currentState.scrollToSelected()
+
 				s.Show()
-				drawBox(s, 0, 0, 130, 38)
+				drawBox(s, 0, 0, width-1, height-2)
 				yPos := 1
-				for idx, file := range currentState.entries {
+				end := currentState.scroll + currentState.rows
+				if end > len(currentState.view) {
+					end = len(currentState.view)
+				}
+				for idx := currentState.scroll; idx < end; idx++ {
+					file := currentState.view[idx]
 					xPos := 1
 					var style tcell.Style
 					if idx == currentState.currentIdx {
@@ -145,82 +426,252 @@ func load() *os.File {
 
 					if file.isDir {
 						drawText(s, xPos, yPos, 9, 1, style, "<dir>")
+					} else if file.isPlaylist {
+						drawText(s, xPos, yPos, 9, 1, style, "<list>")
 					} else {
 						drawText(s, xPos, yPos, 9, 1, style, fmt.Sprintf("%-8d", file.size))
-
 						xPos += 9
 
-						if file.moduleName != nil {
-							drawText(s, xPos, yPos, 20, 1, style, *file.moduleName)
-						} else {
-							m.Lock()
-							path := filepath.Join(currentState.currentDir, file.name)
-							f, err := os.Open(path)
-							if err == nil {
-								defer f.Close()
-								player := mod.NewModPlayer(48000)
-								player.LoadModFile(f)
-								name := player.Song.Name
-								currentState.entries[idx].moduleName = &name
-
-							} else {
-								msg := ""
-								file.moduleName = &msg
-							}
-							m.Unlock()
+						title, format, duration := "...", "", ""
+						if file.scanned {
+							title, format = file.title, file.format
+							duration = formatTime(seconds(file.duration))
 						}
+						drawText(s, xPos, yPos, 24, 1, style, title)
+						xPos += 25
+						drawText(s, xPos, yPos, 6, 1, style, format)
+						xPos += 7
+						drawText(s, xPos, yPos, 6, 1, style, duration)
+						xPos += 7
 
+						favourite := ""
+						if file.record.Favourite {
+							favourite = "♥"
+						}
+						drawText(s, xPos, yPos, 1, 1, style, favourite)
+						xPos += 2
+						drawText(s, xPos, yPos, userdata.MaxRating, 1, style, strings.Repeat("*", file.record.Rating))
+						xPos += userdata.MaxRating + 1
+
+						plays, lastPlayed := "", ""
+						if file.record.PlayCount > 0 {
+							plays = fmt.Sprint(file.record.PlayCount)
+							lastPlayed = file.record.LastPlayed.Local().Format("2006-01-02 15:04")
+						}
+						drawText(s, xPos, yPos, 5, 1, style, plays)
+						xPos += 6
+						drawText(s, xPos, yPos, 16, 1, style, lastPlayed)
 					}
 					yPos++
 				}
+
+				order := "^"
+				if currentState.reverse {
+					order = "v"
+				}
+				info := fmt.Sprintf("%s  Sort: %s %s  Queue: %d  %s", currentState.mode, currentState.sortBy, order, queue.Len(), currentState.status)
+				if currentState.searching || currentState.query != "" {
+					cursor := ""
+					if currentState.searching {
+						cursor = "_"
+					}
+					info = fmt.Sprintf("Search: %s%s  %s", currentState.query, cursor, info)
+				}
+				// The key help goes first when there is room for both
+				if width >= browserHelpWidth {
+					drawText(s, 1, height-1, 70, 1, fileStyle, "/ search  S sort  R reverse  V view  F fav  0-5 rate  A add  W save")
+					drawText(s, 73, height-1, width-74, 1, fileStyle, info)
+				} else {
+					drawText(s, 1, height-1, width-2, 1, fileStyle, info)
+				}
+				currentState.mu.Unlock()
 				time.Sleep(time.Second / 60)
 			}
 		}
 	}(currentState)
 
 	for {
-		switch ev := s.PollEvent().(type) {
-		case *tcell.EventResize:
-			s.Sync()
-		case *tcell.EventKey:
-			switch key := ev.Key(); key {
-			case tcell.KeyDown:
-				if currentState.currentIdx < len(currentState.entries)-1 {
-					currentState.currentIdx++
-				} else {
-					currentState.currentIdx = 0
+		ev := s.PollEvent()
+		currentState.mu.Lock()
+		done, picked := handleBrowserEvent(s, ev, queue, lib, store)
+		currentState.mu.Unlock()
+		if done {
+			terminate <- true
+			return picked
+		}
+	}
+}
+
+// handleBrowserEvent handles one event in the browser with the state
+// locked. done is true when the browser should close, picked when the
+// queue should start playing
+func handleBrowserEvent(s tcell.Screen, ev tcell.Event, queue *playlist.Queue, lib *library.Library, store *userdata.Store) (done bool, picked bool) {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		s.Sync()
+	case *tcell.EventKey:
+		if currentState.searching && handleSearchKey(ev, lib, store) {
+			return false, false
+		}
+		switch key := ev.Key(); key {
+		case tcell.KeyDown:
+			if currentState.currentIdx < len(currentState.view)-1 {
+				currentState.currentIdx++
+			} else {
+				currentState.currentIdx = 0
+			}
+		case tcell.KeyUp:
+			if currentState.currentIdx > 0 {
+				currentState.currentIdx--
+			} else {
+				currentState.currentIdx = len(currentState.view) - 1
+			}
+		case tcell.KeyPgDn:
+			currentState.currentIdx += currentState.rows
+			if currentState.currentIdx >= len(currentState.view) {
+				currentState.currentIdx = len(currentState.view) - 1
+			}
+		case tcell.KeyPgUp:
+			currentState.currentIdx -= currentState.rows
+			if currentState.currentIdx < 0 {
+				currentState.currentIdx = 0
+			}
+		case tcell.KeyEscape:
+			if currentState.query != "" {
+				currentState.query = ""
+				currentState.refreshView(lib, store)
+				return false, false
+			}
+			return true, false
+		case tcell.KeyEnter:
+			if len(currentState.view) == 0 {
+				return false, false
+			}
+			file := currentState.view[currentState.currentIdx]
+			if file.isDir {
+				entries, err := changeDir(s, file.name)
+				if err != nil {
+					panic(err)
 				}
-			case tcell.KeyUp:
-				if currentState.currentIdx > 0 {
-					currentState.currentIdx--
-				} else {
-					currentState.currentIdx = len(currentState.entries) - 1
-				}
-			case tcell.KeyEscape:
-				quit()
-			case tcell.KeyEnter:
-				file := (currentState.entries)[currentState.currentIdx]
-				if file.isDir {
-					entries, err := changeDir(s, file.name)
-					if err != nil {
-						panic(err)
-					}
-					currentState.entries = entries
-					s.Clear()
-				} else {
-					path := filepath.Join(currentState.currentDir, file.name)
-					file, err := os.Open(path)
-					if err != nil {
-						panic(err)
-					}
-					terminate <- true
-					return file
+				currentState.entries = entries
+				currentState.refreshView(lib, store)
+				// Only the directory being looked at is worth scanning
+				lib.Cancel()
+				lib.Scan(currentState.currentDir)
+				s.Clear()
+			} else if file.isPlaylist {
+				entries, err := playlist.Load(file.path)
+				if err != nil {
+					currentState.status = err.Error()
+					return false, false
+				}
+				if len(entries) == 0 {
+					currentState.status = "The playlist is empty"
+					return false, false
+				}
+				queue.Set(entries, 0)
+				return true, true
+			} else {
+				paths, selected := viewModules()
+				queue.Set(paths, selected)
+				return true, true
+			}
+		case tcell.KeyRune:
+			switch ev.Rune() {
+			case '/':
+				currentState.searching = true
+			case 's', 'S':
+				currentState.sortBy = (currentState.sortBy + 1) % (sortByLastPlayed + 1)
+				currentState.refreshView(lib, store)
+			case 'r', 'R':
+				currentState.reverse = !currentState.reverse
+				currentState.refreshView(lib, store)
+			case 'v', 'V':
+				currentState.mode = (currentState.mode + 1) % (recentView + 1)
+				if currentState.mode == recentView {
+					currentState.sortBy = sortByLastPlayed
+					currentState.reverse = false
 				}
-			case tcell.KeyHome:
+				entries, err := currentState.listEntries(store)
+				if err != nil {
+					currentState.status = err.Error()
+					return false, false
+				}
+				currentState.entries = entries
 				currentState.currentIdx = 0
-			case tcell.KeyEnd:
-				currentState.currentIdx = len(currentState.entries) - 1
+				currentState.query = ""
+				currentState.refreshView(lib, store)
+				s.Clear()
+			case 'f', 'F':
+				if file, ok := selectedModule(); ok {
+					store.SetFavourite(file.path, !file.record.Favourite)
+					currentState.refreshView(lib, store)
+				}
+			case '0', '1', '2', '3', '4', '5':
+				if file, ok := selectedModule(); ok {
+					store.SetRating(file.path, int(ev.Rune()-'0'))
+					currentState.refreshView(lib, store)
+				}
+			case 'a', 'A':
+				if len(currentState.view) == 0 {
+					return false, false
+				}
+				file := currentState.view[currentState.currentIdx]
+				if file.name == "../" {
+					return false, false
+				}
+				paths := expandInputs([]string{file.path})
+				queue.Add(paths...)
+				currentState.status = fmt.Sprintf("Added %d", len(paths))
+			case 'w', 'W':
+				path := unusedPath(currentState.currentDir, "queue", ".m3u")
+				if err := playlist.Save(path, queue.Entries()); err != nil {
+					currentState.status = err.Error()
+					return false, false
+				}
+				currentState.status = "Saved " + filepath.Base(path)
+				if entries, err := currentState.listEntries(store); err == nil {
+					currentState.entries = entries
+					currentState.refreshView(lib, store)
+				}
 			}
+		case tcell.KeyHome:
+			currentState.currentIdx = 0
+		case tcell.KeyEnd:
+			currentState.currentIdx = len(currentState.view) - 1
+		}
+	}
+	return false, false
+}
+
+// selectedModule returns the selected entry if it is a module
+func selectedModule() (file, bool) {
+	if currentState.currentIdx < 0 || currentState.currentIdx >= len(currentState.view) {
+		return file{}, false
+	}
+	entry := currentState.view[currentState.currentIdx]
+	return entry, !entry.isDir && !entry.isPlaylist
+}
+
+// handleSearchKey edits the search as it is typed, filtering the view on
+// every key. Keys it doesn't use, like the arrows, return false
+func handleSearchKey(ev *tcell.EventKey, lib *library.Library, store *userdata.Store) bool {
+	switch ev.Key() {
+	case tcell.KeyRune:
+		currentState.query += string(ev.Rune())
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if query := []rune(currentState.query); len(query) > 0 {
+			currentState.query = string(query[:len(query)-1])
 		}
+	case tcell.KeyEnter:
+		currentState.searching = false
+		return true
+	case tcell.KeyEscape:
+		currentState.searching = false
+		currentState.query = ""
+	default:
+		return false
 	}
+	currentState.refreshView(lib, store)
+	return true
 }
@@ -0,0 +1,183 @@
+package main
+
+// The smallest terminal the player view is drawn in
+const minScreenWidth, minScreenHeight = 40, 14
+
+// samplesMinScreenWidth is the narrowest terminal that still shows the
+// sample list next to the patterns
+const samplesMinScreenWidth = 80
+
+// box is a panel's border, corners included, as drawBox takes it
+type box struct {
+	x1, y1, x2, y2 int
+}
+
+// innerWidth returns the number of columns inside the border
+func (b box) innerWidth() int {
+	return b.x2 - b.x1 - 1
+}
+
+// innerHeight returns the number of rows inside the border
+func (b box) innerHeight() int {
+	return b.y2 - b.y1 - 1
+}
+
+// scopeRows is the height of the oscilloscopes inside their box
+const scopeRows = 3
+
+// spectrumRows is the height of the spectrum analyser inside its box
+const spectrumRows = 8
+
+// waveformRows is the height of the sample waveform inside its box, under
+// a row of details about the sample
+const waveformRows = 6
+
+// panelsMinPatternRows is the number of pattern rows the optional panels
+// have to leave to be shown
+const panelsMinPatternRows = 8
+
+// panels is a set of the optional panels the user has toggled on
+type panels int32
+
+const (
+	scopesPanel panels = 1 << iota
+	spectrumPanel
+	waveformPanel
+)
+
+// layout places the player's panels on a screen of the given size. The
+// header takes the top row and the status line the bottom one
+type layout struct {
+	headerY      int
+	statusY      int
+	samples      box
+	showSamples  bool
+	patterns     box
+	scopes       box
+	showScopes   bool
+	spectrum     box
+	showSpectrum bool
+	waveform     box
+	showWaveform bool
+	meters       box
+}
+
+// newLayout lays out a screen of the given size with the optional panels
+// in shown that there is room for. The spectrum analyser goes above the
+// meters, the oscilloscopes and then the waveform under the patterns. ok
+// is false if the screen is too small for the player
+func newLayout(width, height int, shown panels) (layout, bool) {
+	if width < minScreenWidth || height < minScreenHeight {
+		return layout{}, false
+	}
+	l := layout{
+		headerY: 0,
+		statusY: height - 1,
+		meters:  box{1, height - 5, width - 3, height - 2},
+	}
+	top, bottom := 1, l.meters.y1-1
+	// fits says whether a panel of the given height leaves enough rows
+	fits := func(rows int) bool {
+		return bottom-top-1-rows-2 >= panelsMinPatternRows
+	}
+	if shown&spectrumPanel != 0 && fits(spectrumRows) {
+		l.showSpectrum = true
+		l.spectrum = box{1, bottom - spectrumRows - 1, width - 3, bottom}
+		bottom = l.spectrum.y1 - 1
+	}
+
+	l.patterns = box{1, top, width - 3, bottom}
+	if width >= samplesMinScreenWidth {
+		l.showSamples = true
+		l.samples = box{1, top, 28, bottom}
+		l.patterns.x1 = l.samples.x2 + 2
+	}
+	if shown&scopesPanel != 0 && fits(scopeRows) {
+		l.showScopes = true
+		l.scopes = box{l.patterns.x1, bottom - scopeRows - 1, l.patterns.x2, bottom}
+		bottom = l.scopes.y1 - 1
+	}
+	if shown&waveformPanel != 0 && fits(waveformRows+1) {
+		l.showWaveform = true
+		l.waveform = box{l.patterns.x1, bottom - waveformRows - 2, l.patterns.x2, bottom}
+		bottom = l.waveform.y1 - 1
+	}
+	l.patterns.y2 = bottom
+	return l, true
+}
+
+// patternColumn is one way of drawing a channel in the pattern view, from
+// the full note, sample and effect down to just the note
+type patternColumn struct {
+	width  int
+	sample bool
+	effect bool
+}
+
+var patternColumns = []patternColumn{
+	{width: 11, sample: true, effect: true},
+	{width: 7, sample: true},
+	{width: 4},
+}
+
+// minPatternChannels is the number of channels a column format has to fit
+// before the pattern view collapses to a narrower one
+const minPatternChannels = 8
+
+// rowNumberWidth is the width of the order and row number column
+const rowNumberWidth = 5
+
+// pickPatternColumns returns the widest column format that shows every
+// channel, or failing that enough of them to scroll through, and how many
+// channels fit
+func pickPatternColumns(width int, numChannels int) (patternColumn, int) {
+	width -= rowNumberWidth
+	for _, column := range patternColumns {
+		if width/column.width >= numChannels {
+			return column, numChannels
+		}
+	}
+	wanted := minPatternChannels
+	if numChannels < wanted {
+		wanted = numChannels
+	}
+	for _, column := range patternColumns {
+		if width/column.width >= wanted {
+			return column, width / column.width
+		}
+	}
+	column := patternColumns[len(patternColumns)-1]
+	visible := width / column.width
+	if visible < 1 {
+		visible = 1
+	}
+	return column, visible
+}
+
+// patternChannelAt returns the channel whose column in the pattern box b is
+// at x when the channels are scrolled to scroll, or false if x isn't over
+// one
+func patternChannelAt(b box, x int, numChannels int, scroll int) (int, bool) {
+	column, visible := pickPatternColumns(b.innerWidth(), numChannels)
+	offset := x - b.x1 - 1 - rowNumberWidth
+	if offset < 0 || offset/column.width >= visible {
+		return 0, false
+	}
+	channelNum := scroll + offset/column.width
+	if channelNum >= numChannels {
+		return 0, false
+	}
+	return channelNum, true
+}
+
+// clampScroll keeps a scroll offset within a list of total items of which
+// visible fit on screen
+func clampScroll(scroll, total, visible int) int {
+	if scroll > total-visible {
+		scroll = total - visible
+	}
+	if scroll < 0 {
+		scroll = 0
+	}
+	return scroll
+}
@@ -0,0 +1,124 @@
+package main
+
+import "testing"
+
+func TestNewLayout(t *testing.T) {
+	all := scopesPanel | spectrumPanel | waveformPanel
+	tests := []struct {
+		name          string
+		width, height int
+		shown         panels
+		ok            bool
+		patterns      box
+		// Which of the samples, spectrum, scopes and waveform are shown
+		visible [4]bool
+	}{
+		{"too narrow", minScreenWidth - 1, 40, all, false, box{}, [4]bool{}},
+		{"too short", 80, minScreenHeight - 1, all, false, box{}, [4]bool{}},
+		{"smallest", minScreenWidth, minScreenHeight, all, true, box{1, 1, 37, 8}, [4]bool{}},
+		{"no panels", 80, 40, 0, true, box{30, 1, 77, 34}, [4]bool{true, false, false, false}},
+		{"all panels", 80, 40, all, true, box{30, 1, 77, 10}, [4]bool{true, true, true, true}},
+		{"too narrow for samples", 79, 40, scopesPanel, true, box{1, 1, 76, 29}, [4]bool{false, false, true, false}},
+		{"room for scopes only", 80, 24, all, true, box{30, 1, 77, 13}, [4]bool{true, false, true, false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, ok := newLayout(test.width, test.height, test.shown)
+			if ok != test.ok {
+				t.Fatalf("got ok %v, want %v", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+			if l.patterns != test.patterns {
+				t.Errorf("got patterns at %v, want %v", l.patterns, test.patterns)
+			}
+			visible := [4]bool{l.showSamples, l.showSpectrum, l.showScopes, l.showWaveform}
+			if visible != test.visible {
+				t.Errorf("got samples, spectrum, scopes and waveform shown %v, want %v", visible, test.visible)
+			}
+			panelShown := l.showSpectrum || l.showScopes || l.showWaveform
+			if rows := l.patterns.innerHeight(); panelShown && rows < panelsMinPatternRows {
+				t.Errorf("panels leave %d pattern rows", rows)
+			}
+			if l.statusY != test.height-1 || l.meters.y2 >= l.statusY {
+				t.Errorf("meters end on row %d with the status on %d", l.meters.y2, l.statusY)
+			}
+		})
+	}
+}
+
+func TestPickPatternColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		width       int
+		numChannels int
+		column      int
+		visible     int
+	}{
+		{"full", rowNumberWidth + 44, 4, 11, 4},
+		{"without effects", rowNumberWidth + 43, 4, 7, 4},
+		{"notes only", rowNumberWidth + 27, 4, 4, 4},
+		{"too few channels fit", rowNumberWidth + 15, 4, 4, 3},
+		{"many channels scroll", rowNumberWidth + 80, 32, 7, 11},
+		{"many channels fit", rowNumberWidth + 88, 8, 11, 8},
+		{"no room", 3, 4, 4, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			column, visible := pickPatternColumns(test.width, test.numChannels)
+			if column.width != test.column || visible != test.visible {
+				t.Errorf("got %d channels of width %d, want %d of width %d", visible, column.width, test.visible, test.column)
+			}
+		})
+	}
+}
+
+func TestPatternChannelAt(t *testing.T) {
+	// 41 columns for channels, which fits 4 channels of width 7 or 10 of
+	// width 4
+	b := box{30, 1, 77, 10}
+	first := b.x1 + 1 + rowNumberWidth
+	tests := []struct {
+		name        string
+		x           int
+		numChannels int
+		scroll      int
+		channel     int
+		ok          bool
+	}{
+		{"row numbers", first - 1, 4, 0, 0, false},
+		{"first", first, 4, 0, 0, true},
+		{"second", first + 7, 4, 0, 1, true},
+		{"last", first + 3*7 + 6, 4, 0, 3, true},
+		{"after the last", first + 4*7, 4, 0, 0, false},
+		{"scrolled", first, 32, 25, 25, true},
+		{"scrolled past the end", first + 9*4, 32, 25, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			channel, ok := patternChannelAt(b, test.x, test.numChannels, test.scroll)
+			if channel != test.channel || ok != test.ok {
+				t.Errorf("got channel %d, %v, want %d, %v", channel, ok, test.channel, test.ok)
+			}
+		})
+	}
+}
+
+func TestClampScroll(t *testing.T) {
+	tests := []struct {
+		scroll, total, visible int
+		want                   int
+	}{
+		{0, 10, 4, 0},
+		{3, 10, 4, 3},
+		{8, 10, 4, 6},
+		{-2, 10, 4, 0},
+		{2, 3, 4, 0},
+	}
+	for _, test := range tests {
+		if got := clampScroll(test.scroll, test.total, test.visible); got != test.want {
+			t.Errorf("clampScroll(%d, %d, %d) = %d, want %d", test.scroll, test.total, test.visible, got, test.want)
+		}
+	}
+}
@@ -2,15 +2,23 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/zeozeozeo/gomodplay/pkg/library"
 	"github.com/zeozeozeo/gomodplay/pkg/mod"
+	"github.com/zeozeozeo/gomodplay/pkg/playlist"
 	"github.com/zeozeozeo/gomodplay/pkg/speaker"
+	"github.com/zeozeozeo/gomodplay/pkg/spectrum"
+	"github.com/zeozeozeo/gomodplay/pkg/userdata"
 )
 
 var backgroundColour = tcell.GetColor("#282a36")
@@ -31,148 +39,181 @@ var boxBgColour = tcell.GetColor("#282a36")
 var boxFgColour = tcell.GetColor("#526A9E")
 
 var meterColour1 = tcell.GetColor("#E1FA8C")
-
-// var meterColour2 = tcell.GetColor("#50FA7B")
+var meterColour2 = tcell.GetColor("#50FA7B")
 
 var songStyle = tcell.StyleDefault.Background(backgroundColour).Bold(true).Foreground(songColour)
 var sampleStyle = tcell.StyleDefault.Background(sampleBgColour).Foreground(sampleFgColour)
 var sampleHighlightStyle = tcell.StyleDefault.Background(sampleHighlightBgColour).Foreground(sampleHighlightFgColour).Bold(true)
 
-func drawSamples(s tcell.Screen, player *mod.Player) {
-	xPos, yPos := 1, 1
-	width, height := 27, 33
-
-	drawBox(s, xPos, yPos, xPos+width, yPos+height)
-	xPos++
-	yPos++
+// drawSamples draws the sample list in b from scroll on, highlighting the
+// samples that are playing and marking the selected one, if any
+func drawSamples(s tcell.Screen, snapshot mod.Snapshot, b box, scroll int, selected int) {
+	drawBox(s, b.x1, b.y1, b.x2, b.y2)
+	xPos, yPos := b.x1+1, b.y1+1
+	width := b.innerWidth()
 
-	drawText(s, xPos, yPos, width-2, 1, songStyle, player.Song.Name)
+	drawText(s, xPos, yPos, width-1, 1, songStyle, snapshot.Song.Name)
 	yPos++
 
-	currentlyPlaying := make(map[int]bool, len(player.State.Channels))
-	for _, channel := range player.State.Channels {
+	currentlyPlaying := make(map[int]bool, len(snapshot.Channels))
+	for _, channel := range snapshot.Channels {
 		if channel.SampleNum > 0 {
 			currentlyPlaying[int(channel.SampleNum)-1] = true
 		}
 	}
 
-	for idx, sample := range player.Song.Samples {
-		if val := currentlyPlaying[idx]; val {
-			drawText(s, xPos, yPos, width-2, 1, sampleHighlightStyle, fmt.Sprintf("%02d %-20s", idx+1, sample.Name))
-		} else {
-			drawText(s, xPos, yPos, width-2, 1, sampleStyle, fmt.Sprintf("%02d %-20s", idx+1, sample.Name))
+	samples := snapshot.Song.Samples
+	rows := b.innerHeight() - 1
+	for idx := scroll; idx < len(samples) && idx < scroll+rows; idx++ {
+		style := sampleStyle
+		if currentlyPlaying[idx] {
+			style = sampleHighlightStyle
 		}
+		marker := " "
+		if idx == selected {
+			marker = "▶"
+			style = style.Underline(true)
+		}
+		drawText(s, xPos, yPos, width-1, 1, style, fmt.Sprintf("%02d%s%-20s", idx+1, marker, samples[idx].Name))
 		yPos++
 	}
-}
-
-var sampleSize = 8
-var leftValues = make([]float32, sampleSize)
-var rightValues = make([]float32, sampleSize)
 
-func drawMeters(s tcell.Screen, player *mod.Player) {
-	x, y := 1, 35
-	width, height := 126, 3
-	drawBox(s, x, y, x+width, y+height)
-	xPos := x + 1
-	yPos := y + 1
-
-	left, right := player.State.SampleValues()
-	if left < 0 {
-		left = -left
-	}
-	if right < 0 {
-		right = -right
-	}
-	if left > 1 {
-		left = 1
+	borderStyle := tcell.StyleDefault.Background(boxBgColour).Foreground(boxFgColour)
+	if scroll > 0 {
+		s.SetContent(b.x2, b.y1+2, '▲', nil, borderStyle)
 	}
-	if right > 1 {
-		right = 1
+	if scroll+rows < len(samples) {
+		s.SetContent(b.x2, b.y2-1, '▼', nil, borderStyle)
 	}
+}
 
-	leftValues = append(leftValues[1:], left)
-	rightValues = append(rightValues[1:], right)
-
-	var leftSum float32
-	var rightSum float32
-	for i := 0; i < sampleSize; i++ {
-		leftSum += leftValues[i]
-		rightSum += rightValues[i]
-	}
-	leftAvg := leftSum / float32(sampleSize)
-	rightAvg := rightSum / float32(sampleSize)
+// meterRange is the number of decibels between an empty and a full meter
+const meterRange = 60
 
-	leftDB := 20 * math.Log10(float64(leftAvg))
-	rightDB := 20 * math.Log10(float64(rightAvg))
-	if leftDB > 0 {
-		leftDB = 0
+// decibels converts a level to dB, between -meterRange and 0
+func decibels(value float32) float64 {
+	db := 20 * math.Log10(float64(value))
+	if db > 0 {
+		db = 0
 	}
-	if rightDB > 0 {
-		rightDB = 0
+	if db < -meterRange {
+		db = -meterRange
 	}
-	if leftDB < -96 {
-		leftDB = -96
-	}
-	if rightDB < -96 {
-		rightDB = -96
-	}
-
-	style := tcell.StyleDefault.Background(backgroundColour).Foreground(meterColour1)
+	return db
+}
 
+func drawMeterBar(s tcell.Screen, x, y, width int, db float64, style tcell.Style) {
 	runes := []string{"▏", "▎", "▍", "▌", "▋", "▊", "▉", "█"}
-	leftLength := float32(width-2) * float32(96+leftDB) / 96
-	rightLength := float32(width-2) * float32(96+rightDB) / 96
+	length := float32(width) * float32(meterRange+db) / meterRange
 
-	for i := 0; i < int(leftLength); i++ {
-		drawText(s, xPos, yPos, 1, 1, style, runes[7])
+	xPos := x
+	for i := 0; i < int(length); i++ {
+		drawText(s, xPos, y, 1, 1, style, runes[7])
 		xPos++
 	}
-	remainder := leftLength - float32(int(leftLength))
+	remainder := length - float32(int(length))
 	if remainder >= 0.125 {
 		idx := int(remainder*8) - 1
-		drawText(s, xPos, yPos, 1, 1, style, runes[idx])
-		xPos++
+		drawText(s, xPos, y, 1, 1, style, runes[idx])
 	}
+}
 
-	yPos++
-	xPos = x + 1
-	for i := 0; i < int(rightLength); i++ {
-		drawText(s, xPos, yPos, 1, 1, style, runes[7])
-		xPos++
+// drawLevel draws a meter's peak with its RMS level over it
+func drawLevel(s tcell.Screen, x, y, width int, meter mod.Meter) {
+	peakStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(meterColour2)
+	rmsStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(meterColour1)
+	drawMeterBar(s, x, y, width, decibels(meter.Peak), peakStyle)
+	drawMeterBar(s, x, y, width, decibels(meter.RMS), rmsStyle)
+}
+
+// drawMeters draws the output level of both sides, with the part the
+// limiter took off past the end of the bars
+func drawMeters(s tcell.Screen, snapshot mod.Snapshot, b box) {
+	drawBox(s, b.x1, b.y1, b.x2, b.y2)
+	x, y := b.x1+1, b.y1+1
+	width := b.innerWidth() - 10
+
+	preStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(effectColour)
+	textStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(sampleFgColour)
+	sides := []struct {
+		meter, preLimiter mod.Meter
+	}{
+		{snapshot.LeftMeter, snapshot.PreLimiterLeftMeter},
+		{snapshot.RightMeter, snapshot.PreLimiterRightMeter},
 	}
-	if remainder >= 0.125 {
-		idx := int(remainder*8) - 1
-		drawText(s, xPos, yPos, 1, 1, style, runes[idx])
-		xPos++
+	for idx, side := range sides {
+		drawMeterBar(s, x, y+idx, width, decibels(side.preLimiter.Peak), preStyle)
+		drawLevel(s, x, y+idx, width, side.meter)
+		drawText(s, x+width+1, y+idx, 9, 1, textStyle, fmt.Sprintf("%5.1f dB", decibels(side.meter.RMS)))
 	}
 }
 
-func drawPatterns(s tcell.Screen, player *mod.Player) {
-	x, y := 33, 1
-	width, height := 94, 33
-	drawBox(s, x, y, x+width, y+height)
-	xPos := x + 1
-	yPos := y + 1
+// drawPatterns draws the rows around the one playing in b under the
+// channels' levels, showing the channels from scroll on in the widest
+// format that fits. Each channel's number and whether it is muted (M) or
+// soloed (S) go on the top border, with the selected channel highlighted
+func drawPatterns(s tcell.Screen, snapshot mod.Snapshot, b box, scroll int, selected int) {
+	drawBox(s, b.x1, b.y1, b.x2, b.y2)
+	xPos := b.x1 + 1
+	yPos := b.y1 + 1
+	width := b.innerWidth()
 
 	defaultStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(tcell.GetColor("#626A86"))
 	highlightStyle := tcell.StyleDefault.Background(patternHighlightBgColor).Foreground(patternHighlightFgColor).Bold(true)
-	numRows := 32
-	var lineIdx int
-	if player.State.CurrentLine < 16 {
+	borderStyle := tcell.StyleDefault.Background(boxBgColour).Foreground(boxFgColour)
+
+	numChannels := len(snapshot.Channels)
+	column, visible := pickPatternColumns(width, numChannels)
+	for idx := scroll; idx < scroll+visible && idx < numChannels; idx++ {
+		channel := snapshot.Channels[idx]
+		label := strconv.Itoa(idx + 1)
+		style := borderStyle
+		if channel.Soloed {
+			label += "S"
+			style = style.Foreground(songColour)
+		} else if channel.Muted {
+			label += "M"
+			style = style.Foreground(effectColour)
+		}
+		if idx == selected {
+			style = highlightStyle
+		}
+		if len(label) > column.width-1 {
+			label = label[:column.width-1]
+		}
+		x := xPos + rowNumberWidth + (idx-scroll)*column.width + 1
+		drawText(s, x, b.y1, len(label), 1, style, label)
+	}
+	if visible < numChannels {
+		label := fmt.Sprintf(" ◀ %d-%d/%d ▶ ", scroll+1, scroll+visible, numChannels)
+		drawText(s, b.x2-len([]rune(label))-1, b.y1, len([]rune(label)), 1, borderStyle, label)
+	}
+
+	// Each channel's level goes above its column
+	drawText(s, xPos, yPos, rowNumberWidth, 1, defaultStyle, "Level")
+	for idx := scroll; idx < scroll+visible && idx < numChannels; idx++ {
+		x := xPos + rowNumberWidth + (idx-scroll)*column.width
+		drawText(s, x, yPos, 1, 1, defaultStyle, "│")
+		drawLevel(s, x+1, yPos, column.width-1, snapshot.Channels[idx].Meter)
+	}
+	yPos++
+
+	numRows := b.innerHeight() - 1
+	if numRows > 64 {
+		numRows = 64
+	}
+	lineIdx := int(snapshot.Row) - numRows/2
+	if lineIdx > 64-numRows {
+		lineIdx = 64 - numRows
+	}
+	if lineIdx < 0 {
 		lineIdx = 0
-	} else if player.State.CurrentLine > 48 {
-		lineIdx = 32
-	} else {
-		lineIdx = int(player.State.CurrentLine) - 16
 	}
 
-	for rowNum := 0; rowNum < numRows && lineIdx < 64; rowNum++ {
+	pattern := snapshot.Song.Patterns[snapshot.Pattern]
+	for rowNum := 0; rowNum < numRows; rowNum++ {
 		var style tcell.Style
-		patternIdx := player.Song.Positions[player.State.SongPatternPosition]
-		pattern := player.Song.Patterns[patternIdx]
-
-		if uint32(lineIdx) == player.State.CurrentLine {
+		if uint32(lineIdx) == snapshot.Row {
 			style = highlightStyle
 		} else {
 			style = defaultStyle
@@ -180,37 +221,48 @@ func drawPatterns(s tcell.Screen, player *mod.Player) {
 
 		row := pattern.Rows[lineIdx]
 
-		rowNumber := fmt.Sprintf("%02d.%02d", player.State.SongPatternPosition, lineIdx)
-		drawText(s, xPos, yPos, width-2, 1, style, rowNumber)
-		xPos += 5
+		rowNumber := fmt.Sprintf("%02d.%02d", snapshot.Order, lineIdx)
+		drawText(s, xPos, yPos, width-1, 1, style, rowNumber)
+		xPos += rowNumberWidth
 
-		for idx, note := range row {
+		for idx := scroll; idx < scroll+visible && idx < len(row); idx++ {
+			note := row[idx]
 			drawText(s, xPos, yPos, 1, 1, style, "│")
 			xPos++
 			noteStyle := style
 			sampleStyle := style
 			effectStyle := style
 
-			if !player.State.Channels[idx].Muted {
+			if snapshot.Channels[idx].Audible {
 				noteStyle = style.Foreground(patternNoteFgColour)
 				sampleStyle = style.Foreground(patternSampleFgColour)
 				effectStyle = style.Foreground(effectColour)
 			}
 
 			if note.NoteName != "" {
-				drawText(s, xPos, yPos, 4, 1, noteStyle, note.NoteName+" ")
+				drawText(s, xPos, yPos, 3, 1, noteStyle, note.NoteName)
 			} else {
-				drawText(s, xPos, yPos, 4, 1, style, "... ")
+				drawText(s, xPos, yPos, 3, 1, style, "...")
 			}
-			xPos += 4
+			xPos += 3
+			if !column.sample {
+				continue
+			}
+			drawText(s, xPos, yPos, 1, 1, style, " ")
+			xPos++
 
 			if note.SampleNumber > 0 {
 				sampleNumber := fmt.Sprintf("%02d", note.SampleNumber)
-				drawText(s, xPos, yPos, 3, 1, sampleStyle, sampleNumber)
+				drawText(s, xPos, yPos, 2, 1, sampleStyle, sampleNumber)
 			} else {
-				drawText(s, xPos, yPos, 3, 1, style, "..")
+				drawText(s, xPos, yPos, 2, 1, style, "..")
 			}
-			xPos += 3
+			xPos += 2
+			if !column.effect {
+				continue
+			}
+			drawText(s, xPos, yPos, 1, 1, style, " ")
+			xPos++
 
 			if note.Effect > 0 {
 				effect := fmt.Sprintf("%x%02x", note.Effect, note.EffectArgument)
@@ -221,34 +273,245 @@ func drawPatterns(s tcell.Screen, player *mod.Player) {
 			xPos += 3
 		}
 		lineIdx++
-		xPos = x + 1
+		xPos = b.x1 + 1
 		yPos++
 	}
 }
 
-func main() {
-	sampleRate := uint32(48000)
+// heardSnapshot takes a snapshot with its position moved back by the
+// speaker's latency, so the pattern view shows the row being heard
+func heardSnapshot(player *mod.Player, sp *speaker.Speaker, sampleRate uint32) mod.Snapshot {
+	snapshot := player.Snapshot()
+	lag := uint64(sp.Latency().Seconds() * float64(sampleRate))
+	if lag > snapshot.SamplePosition {
+		return snapshot
+	}
+	if order, pattern, row, ok := player.RowAt(snapshot.SamplePosition - lag); ok {
+		snapshot.Order = order
+		snapshot.Pattern = pattern
+		snapshot.Row = row
+	}
+	return snapshot
+}
+
+// heardElapsed returns how far into the song the output being heard is,
+// which lags the mixer by the speaker's latency
+func heardElapsed(player *mod.Player, sp *speaker.Speaker) time.Duration {
+	elapsed := player.Elapsed() - sp.Latency()
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
+
+// currentPlayer holds the song being heard, which the playlist swaps from
+// the speaker's goroutine
+type currentPlayer struct {
+	mu   sync.Mutex
+	song queuedSong
+}
+
+func (c *currentPlayer) get() *mod.Player {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.song.Player
+}
+
+func (c *currentPlayer) path() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.song.path
+}
+
+func (c *currentPlayer) queuePosition() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.song.position
+}
+
+func (c *currentPlayer) set(song queuedSong) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.song = song
+}
+
+// handOver makes song the one being heard, carrying over the settings of
+// the last
+func (c *currentPlayer) handOver(song queuedSong) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.song.Player != nil && c.song.Player != song.Player {
+		song.ApplySettings(c.song.Settings())
+	}
+	c.song = song
+}
+
+// changeSettings calls change with the song being heard and its settings.
+// The song isn't handed over meanwhile, so the change isn't lost when it
+// is, and changes made one after another each see the last
+func (c *currentPlayer) changeSettings(change func(player *mod.Player, settings mod.Settings)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	change(c.song.Player, c.song.Settings())
+}
+
+// queuedSong is a player streamed by the playlist, remembering its file and
+// where in the queue it came from, as the next song is loaded before this
+// one is heard
+type queuedSong struct {
+	*mod.Player
+	path     string
+	position int
+}
+
+// openPlayer loads a module into a new player with the given settings
+func openPlayer(f *os.File, sampleRate uint32, settings mod.Settings) (*mod.Player, error) {
 	player := mod.NewModPlayer(sampleRate)
+	if err := player.LoadModFile(f); err != nil {
+		return nil, err
+	}
+	player.ApplySettings(settings)
+	return player, player.Play()
+}
 
-	loading := true
-	f := load()
-	loading = false
-	err := player.LoadModFile(f)
-	if err != nil {
-		panic(err)
+// openNext opens the next playable file in the queue, skipping those that
+// can't be loaded. It gives up after trying every entry once
+func openNext(queue *playlist.Queue, sampleRate uint32, settings mod.Settings) (queuedSong, bool) {
+	for tries := queue.Len(); tries > 0; tries-- {
+		path, ok := queue.Next()
+		if !ok {
+			break
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		player, err := openPlayer(f, sampleRate, settings)
+		f.Close()
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			continue
+		}
+		return queuedSong{player, path, queue.Position()}, true
 	}
-	f.Close()
+	return queuedSong{}, false
+}
 
-	err = speaker.Init(sampleRate, sampleRate/100)
-	if err != nil {
-		panic(err)
+// newPlaylist plays the queue from its next entry, returning nil when none
+// of its files can be played. Settings changed while a song plays carry
+// over to the next, and nowPlaying follows the song being heard. onFinish,
+// if not nil, is called with the path of each song that plays to its end
+func newPlaylist(nowPlaying *currentPlayer, queue *playlist.Queue, sampleRate uint32, settings mod.Settings, crossfade time.Duration, onFinish func(path string)) *speaker.Playlist {
+	open := func(settings mod.Settings) (speaker.Streamer, bool) {
+		song, ok := openNext(queue, sampleRate, settings)
+		if !ok {
+			return nil, false
+		}
+		if onFinish != nil {
+			song.AddEventHandler(func(event mod.Event) {
+				if event.Type == mod.SongEndEvent {
+					onFinish(song.path)
+				}
+			})
+		}
+		return song, true
 	}
 
-	done := make(chan bool)
-	player.Play()
-	speaker.Play(player, func() {
-		done <- true
+	first, ok := open(settings)
+	if !ok {
+		return nil
+	}
+	nowPlaying.set(first.(queuedSong))
+
+	next := func() speaker.Streamer {
+		if first != nil {
+			song := first
+			first = nil
+			return song
+		}
+		if song, ok := open(nowPlaying.get().Settings()); ok {
+			return song
+		}
+		return nil
+	}
+
+	playlist := speaker.NewPlaylist(next, int(crossfade.Seconds()*float64(sampleRate)))
+	playlist.OnChange(func(s speaker.Streamer) {
+		nowPlaying.handOver(s.(queuedSong))
 	})
+	return playlist
+}
+
+// openStore opens the user's favourites and history, falling back to one
+// that isn't saved when it can't be read
+func openStore() *userdata.Store {
+	store, err := userdata.Open(userdata.DefaultPath())
+	if err != nil {
+		log.Printf("favourites and history won't be saved: %v", err)
+		store, _ = userdata.Open("")
+	}
+	return store
+}
+
+// recordPlay returns a callback counting the plays of finished songs
+func recordPlay(store *userdata.Store) func(path string) {
+	return func(path string) {
+		if err := store.RecordPlay(path, time.Now()); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// seekStep is how far the arrow keys seek
+const seekStep = 10 * time.Second
+
+// tempoStep is how much t and T change the tempo scale by
+const tempoStep = 0.05
+
+// mixingPresets are the mixing modes M cycles through
+var mixingPresets = []mod.MixingMode{mod.AmigaMixingMode, mod.StereoMixingMode, mod.MonoMixingMode}
+
+// nextMixingPreset returns the preset after mode, or the first one when
+// mode is a custom separation
+func nextMixingPreset(mode mod.MixingMode) mod.MixingMode {
+	for idx, preset := range mixingPresets {
+		if preset == mode {
+			return mixingPresets[(idx+1)%len(mixingPresets)]
+		}
+	}
+	return mixingPresets[0]
+}
+
+// runUI plays the queue, or modules picked in the browser when it is
+// empty, with the tracker view on screen
+func runUI(sp *speaker.Speaker, sampleRate uint32, settings mod.Settings, crossfade time.Duration, queue *playlist.Queue) {
+	store := openStore()
+	lib := library.New(library.DefaultCachePath(), isModule)
+	loading := true
+	if queue.Len() == 0 && !load(queue, lib, store) {
+		lib.Close()
+		return
+	}
+	loading = false
+
+	var nowPlaying currentPlayer
+	var songs *speaker.Playlist
+	done := make(chan bool, 1)
+	play := func(settings mod.Settings) bool {
+		playlist := newPlaylist(&nowPlaying, queue, sampleRate, settings, crossfade, recordPlay(store))
+		if playlist == nil {
+			return false
+		}
+		songs = playlist
+		sp.Play(playlist, func() {
+			done <- true
+		})
+		return true
+	}
+	if !play(settings) {
+		log.Fatal("none of the files could be played")
+	}
 
 	defStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(tcell.ColorReset)
 
@@ -261,59 +524,293 @@ func main() {
 		log.Fatalf("%+v", err)
 	}
 	s.SetStyle(defStyle)
+	s.EnableMouse(tcell.MouseButtonEvents)
 	s.Clear()
+	// Files skipped in the queue would be logged over the screen
+	log.SetOutput(ioutil.Discard)
+
+	// Hand the end of the playlist to the event loop
+	go func() {
+		for range done {
+			s.PostEvent(tcell.NewEventInterrupt(nil))
+		}
+	}()
 
 	// Event loop
 	quit := func() {
 		s.Fini()
+		log.SetOutput(os.Stderr)
+		if err := lib.Close(); err != nil {
+			log.Print(err)
+		}
+		if err := sp.Close(); err != nil {
+			log.Fatal(err)
+		}
 		os.Exit(0)
 	}
 
+	// Scroll offsets of the pattern channels and the sample list, clamped
+	// by the draw loop as the layout changes
+	var channelScroll, sampleScroll int32
+	// selectedSample is the sample shown in the waveform panel
+	var selectedSample int32
+	// selectedChannel is the channel the mute and solo keys act on
+	var selectedChannel int32
+	// shownPanels holds the optional panels toggled on, as panels
+	var shownPanels int32
+	togglePanel := func(panel panels) {
+		atomic.StoreInt32(&shownPanels, atomic.LoadInt32(&shownPanels)^int32(panel))
+	}
+	// sampleRows returns the number of samples the sample list shows
+	sampleRows := func() int {
+		width, height := s.Size()
+		if lay, ok := newLayout(width, height, panels(atomic.LoadInt32(&shownPanels))); ok && lay.samples.innerHeight() > 2 {
+			return lay.samples.innerHeight() - 1
+		}
+		return 1
+	}
+	// songChannels returns the number of channels in the current song
+	songChannels := func() int {
+		var numChannels int
+		nowPlaying.get().View(func(player *mod.Player) {
+			if player.Song != nil {
+				numChannels = int(player.Song.NumChannels)
+			}
+		})
+		return numChannels
+	}
+	// selectChannel selects a channel of the current song and scrolls the
+	// patterns to show it
+	selectChannel := func(channelNum int) {
+		numChannels := songChannels()
+		channelNum = clampScroll(channelNum, numChannels, 1)
+		atomic.StoreInt32(&selectedChannel, int32(channelNum))
+
+		width, height := s.Size()
+		lay, ok := newLayout(width, height, panels(atomic.LoadInt32(&shownPanels)))
+		if !ok {
+			return
+		}
+		_, visible := pickPatternColumns(lay.patterns.innerWidth(), numChannels)
+		if scroll := int(atomic.LoadInt32(&channelScroll)); channelNum < scroll {
+			atomic.StoreInt32(&channelScroll, int32(channelNum))
+		} else if channelNum >= scroll+visible {
+			atomic.StoreInt32(&channelScroll, int32(channelNum-visible+1))
+		}
+	}
+	// changeQueue changes how the queue plays, taking back the song
+	// already loaded to play next so the change applies straight after the
+	// one being heard
+	changeQueue := func(change func()) {
+		songs.Reload(func(dropped bool) {
+			if dropped {
+				queue.SetPosition(nowPlaying.queuePosition())
+			}
+			change()
+		})
+	}
+	// lastButtons is the mouse buttons held at the last mouse event, so
+	// only presses act
+	var lastButtons tcell.ButtonMask
+
 	go func() {
+		var lastWidth, lastHeight int
+		var analyser *spectrum.Analyser
+		heard := make([]float32, spectrum.WindowSize)
 		for {
 			if loading {
 				continue
 			}
 			s.Show()
+			width, height := s.Size()
+			if width != lastWidth || height != lastHeight {
+				lastWidth, lastHeight = width, height
+				s.Clear()
+			}
+			lay, ok := newLayout(width, height, panels(atomic.LoadInt32(&shownPanels)))
+			if !ok {
+				drawText(s, 0, 0, width, 1, defStyle.Foreground(effectColour), "Terminal too small")
+				time.Sleep(time.Second / 60)
+				continue
+			}
+
+			player := nowPlaying.get()
+			snapshot := heardSnapshot(player, sp, sampleRate)
+			_, visibleChannels := pickPatternColumns(lay.patterns.innerWidth(), len(snapshot.Channels))
+			channels := clampScroll(int(atomic.LoadInt32(&channelScroll)), len(snapshot.Channels), visibleChannels)
+			atomic.StoreInt32(&channelScroll, int32(channels))
+			samples := clampScroll(int(atomic.LoadInt32(&sampleScroll)), len(snapshot.Song.Samples), lay.samples.innerHeight()-1)
+			atomic.StoreInt32(&sampleScroll, int32(samples))
+			selected := clampScroll(int(atomic.LoadInt32(&selectedSample)), len(snapshot.Song.Samples), 1)
+			atomic.StoreInt32(&selectedSample, int32(selected))
+			if !lay.showWaveform {
+				selected = -1
+			}
+			channel := clampScroll(int(atomic.LoadInt32(&selectedChannel)), len(snapshot.Channels), 1)
+			atomic.StoreInt32(&selectedChannel, int32(channel))
+
 			start := time.Now()
-			drawSamples(s, player)
-			drawPatterns(s, player)
-			drawMeters(s, player)
+			if lay.showSamples {
+				drawSamples(s, snapshot, lay.samples, samples, selected)
+			}
+			drawPatterns(s, snapshot, lay.patterns, channels, channel)
+			if lay.showScopes {
+				drawScopes(s, snapshot, lay.scopes, channels)
+			}
+			if lay.showWaveform {
+				drawWaveform(s, snapshot, lay.waveform, selected)
+			}
+			if lay.showSpectrum {
+				if analyser == nil || analyser.NumBands() != lay.spectrum.innerWidth() {
+					analyser = spectrum.New(sampleRate, lay.spectrum.innerWidth())
+				}
+				sp.Heard(heard)
+				analyser.Update(heard)
+				drawSpectrum(s, analyser, lay.spectrum)
+			}
+			drawMeters(s, snapshot, lay.meters)
 			end := time.Since(start)
 
-			xPos, yPos := 2, 0
+			elapsed := heardElapsed(player, sp)
+			duration := player.Duration()
+			// Copy what the header shows rather than drawing with the mixer
+			// locked
+			settings := player.Settings()
+			paused := player.Paused()
+			xPos, yPos := 2, lay.headerY
 
 			drawText(s, xPos, yPos, 0, 132, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "M")
 			xPos++
 			drawText(s, xPos, yPos, 12, 1, defStyle.Foreground(sampleFgColour).Bold(true), "ixing mode:")
 			xPos += 12
-			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(effectColour), player.MixingMode.String())
+			mixingMode := settings.MixingMode.String()
+			if settings.MixingMode == mod.CustomMixingMode {
+				mixingMode = fmt.Sprintf("%d%%", settings.StereoSeparation)
+			}
+			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(effectColour), mixingMode)
 			xPos += 8
 
 			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "S")
 			xPos++
 			drawText(s, xPos, yPos, 9, 1, defStyle.Foreground(sampleFgColour).Bold(true), "tandard:")
 			xPos += 9
-			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(effectColour), string(player.Standard))
+			standard := string(settings.Standard)
+			if settings.VBlankTiming {
+				standard += " vbl"
+			}
+			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(effectColour), standard)
 			xPos += 8
 
-			xPos = 64
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "X")
+			xPos++
+			drawText(s, xPos, yPos, 7, 1, defStyle.Foreground(sampleFgColour).Bold(true), "-feed:")
+			xPos += 7
+			crossfeed := "off"
+			if settings.Crossfeed {
+				crossfeed = "on"
+			}
+			drawText(s, xPos, yPos, 4, 1, defStyle.Foreground(effectColour), crossfeed)
+			xPos += 4
+
+			xPos += 2
 			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Format:")
 			xPos += 8
-			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(patternSampleFgColour), player.Song.Format.Tag)
+			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(patternSampleFgColour), snapshot.Song.Format.Tag)
 			xPos += 5
 
 			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Position:")
 			xPos += 10
-			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(patternSampleFgColour), fmt.Sprintf("%d/%d", player.State.SongPatternPosition, player.Song.NumUsedPatterns))
+			drawText(s, xPos, yPos, 8, 1, defStyle.Foreground(patternSampleFgColour), fmt.Sprintf("%d/%d", snapshot.Order, snapshot.Song.NumUsedPatterns))
+			xPos += 6
+
+			xPos += 4
+			drawText(s, xPos, yPos, 4, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Vol:")
+			xPos += 4
+			drawText(s, xPos, yPos, 6, 1, defStyle.Foreground(effectColour), fmt.Sprintf("%d%%", int(settings.MasterVolume*100+0.5)))
+			xPos += 6
+
+			drawText(s, xPos, yPos, 5, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Time:")
+			xPos += 6
+			position := formatTime(elapsed) + "/" + formatTime(duration)
+			if paused {
+				position += " paused"
+			}
+			drawText(s, xPos, yPos, 18, 1, defStyle.Foreground(patternSampleFgColour), position)
+			xPos += 18
+
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "T")
+			xPos++
+			drawText(s, xPos, yPos, 5, 1, defStyle.Foreground(sampleFgColour).Bold(true), "empo:")
+			xPos += 6
+			drawText(s, xPos, yPos, 6, 1, defStyle.Foreground(effectColour), fmt.Sprintf("%d%%", int(settings.TempoScale*100+0.5)))
+			xPos += 6
+
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "P")
+			xPos++
+			drawText(s, xPos, yPos, 5, 1, defStyle.Foreground(sampleFgColour).Bold(true), "itch:")
+			xPos += 6
+			drawText(s, xPos, yPos, 4, 1, defStyle.Foreground(effectColour), fmt.Sprintf("%+d", settings.PitchShift))
 			xPos += 6
 
-			xPos = 106
 			drawText(s, xPos, yPos, 13, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Render time:")
 			xPos += 13
 			drawText(s, xPos, yPos, 14, 1, defStyle.Foreground(effectColour), fmt.Sprintf("%v", end))
 			xPos += 14
 
+			xPos, yPos = 2, lay.statusY
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "C")
+			xPos++
+			drawText(s, xPos, yPos, 13, 1, defStyle.Foreground(sampleFgColour).Bold(true), "ompatibility:")
+			xPos += 14
+			drawText(s, xPos, yPos, 16, 1, defStyle.Foreground(effectColour), settings.Compatibility.String())
+			xPos += 18
+
+			drawText(s, xPos, yPos, 9, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Latency:")
+			xPos += 9
+			drawText(s, xPos, yPos, 10, 1, defStyle.Foreground(effectColour), sp.Latency().Round(time.Millisecond).String())
+			xPos += 12
+
+			drawText(s, xPos, yPos, 7, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Queue:")
+			xPos += 7
+			drawText(s, xPos, yPos, 10, 1, defStyle.Foreground(effectColour), fmt.Sprintf("%d/%d", nowPlaying.queuePosition(), queue.Len()))
+			xPos += 10
+
+			drawText(s, xPos, yPos, 2, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Sh")
+			xPos += 2
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "u")
+			xPos++
+			drawText(s, xPos, yPos, 6, 1, defStyle.Foreground(sampleFgColour).Bold(true), "ffle:")
+			xPos += 6
+			shuffle := "off"
+			if queue.Shuffle() {
+				shuffle = "on"
+			}
+			drawText(s, xPos, yPos, 4, 1, defStyle.Foreground(effectColour), shuffle)
+			xPos += 4
+
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "R")
+			xPos++
+			drawText(s, xPos, yPos, 7, 1, defStyle.Foreground(sampleFgColour).Bold(true), "epeat:")
+			xPos += 7
+			drawText(s, xPos, yPos, 4, 1, defStyle.Foreground(effectColour), queue.Repeat().String())
+			xPos += 4
+
+			record := store.Get(nowPlaying.path())
+			drawText(s, xPos, yPos, 1, 1, defStyle.Foreground(sampleFgColour).Bold(true).Underline(true), "F")
+			xPos++
+			drawText(s, xPos, yPos, 10, 1, defStyle.Foreground(sampleFgColour).Bold(true), "avourite:")
+			xPos += 10
+			favourite := "no"
+			if record.Favourite {
+				favourite = "yes"
+			}
+			drawText(s, xPos, yPos, 4, 1, defStyle.Foreground(effectColour), favourite)
+			xPos += 4
+
+			drawText(s, xPos, yPos, 10, 1, defStyle.Foreground(sampleFgColour).Bold(true), "Rating (*):")
+			xPos += 12
+			drawText(s, xPos, yPos, userdata.MaxRating, 1, defStyle.Foreground(effectColour), strings.Repeat("*", record.Rating))
+
 			time.Sleep(time.Second / 60)
 		}
 	}()
@@ -329,7 +826,80 @@ func main() {
 		switch ev := ev.(type) {
 		case *tcell.EventResize:
 			s.Sync()
+		case *tcell.EventInterrupt:
+			// The last song in the playlist finished
+			quit()
+		case *tcell.EventMouse:
+			// Clicking a channel's header in the patterns mutes it, or
+			// with the right button soloes it
+			pressed := ev.Buttons() &^ lastButtons
+			lastButtons = ev.Buttons()
+			if pressed&(tcell.Button1|tcell.Button2) == 0 {
+				break
+			}
+			width, height := s.Size()
+			lay, ok := newLayout(width, height, panels(atomic.LoadInt32(&shownPanels)))
+			x, y := ev.Position()
+			if !ok || y < lay.patterns.y1 || y > lay.patterns.y1+1 {
+				break
+			}
+			channelNum, ok := patternChannelAt(lay.patterns, x, songChannels(), int(atomic.LoadInt32(&channelScroll)))
+			if !ok {
+				break
+			}
+			atomic.StoreInt32(&selectedChannel, int32(channelNum))
+			player := nowPlaying.get()
+			if pressed&tcell.Button1 != 0 {
+				player.ToggleMute(channelNum)
+			} else {
+				player.ToggleSolo(channelNum)
+			}
 		case *tcell.EventKey:
+			player := nowPlaying.get()
+			settings := player.Settings()
+			switch ev.Key() {
+			case tcell.KeyLeft, tcell.KeyRight:
+				// Shift scrolls through the channels, otherwise these seek
+				step := int32(1)
+				if ev.Key() == tcell.KeyLeft {
+					step = -1
+				}
+				if ev.Modifiers()&tcell.ModShift != 0 {
+					atomic.AddInt32(&channelScroll, step)
+				} else {
+					player.SeekTime(heardElapsed(player, sp) + time.Duration(step)*seekStep)
+				}
+			case tcell.KeyHome:
+				player.Restart()
+			case tcell.KeyTab:
+				selectChannel(int(atomic.LoadInt32(&selectedChannel)) + 1)
+			case tcell.KeyBacktab:
+				selectChannel(int(atomic.LoadInt32(&selectedChannel)) - 1)
+			case tcell.KeyEnter:
+				player.ToggleMute(int(atomic.LoadInt32(&selectedChannel)))
+			case tcell.KeyPgUp, tcell.KeyPgDn:
+				page := sampleRows()
+				if ev.Key() == tcell.KeyPgUp {
+					page = -page
+				}
+				atomic.AddInt32(&sampleScroll, int32(page))
+			case tcell.KeyUp, tcell.KeyDown:
+				// Picking a sample shows its waveform
+				if panels(atomic.LoadInt32(&shownPanels))&waveformPanel == 0 {
+					togglePanel(waveformPanel)
+				} else if ev.Key() == tcell.KeyUp {
+					atomic.AddInt32(&selectedSample, -1)
+				} else {
+					atomic.AddInt32(&selectedSample, 1)
+				}
+				// Keep the selected sample in view
+				selected, scroll, rows := atomic.LoadInt32(&selectedSample), atomic.LoadInt32(&sampleScroll), int32(sampleRows())
+				if selected < scroll {
+					atomic.StoreInt32(&sampleScroll, selected)
+				} else if selected >= scroll+rows {
+					atomic.StoreInt32(&sampleScroll, selected-rows+1)
+				}
+			}
 			if ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC {
 				quit()
 			} else {
@@ -338,28 +908,118 @@ func main() {
 				case 'L', 'l':
 					loading = true
 					s.Suspend()
-					f := load()
-					err = player.LoadModFile(f)
-					if err != nil {
-						panic(err)
+					if load(queue, lib, store) {
+						play(settings)
 					}
 					s.Resume()
 					s.Clear()
 					loading = false
 				case 'M', 'm':
-					player.MixingMode = (player.MixingMode + 1) % 3
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetMixingMode(nextMixingPreset(settings.MixingMode))
+					})
+				case '[':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						if separation := settings.StereoSeparation; separation >= 10 {
+							player.SetStereoSeparation(separation - 10)
+						} else {
+							player.SetStereoSeparation(0)
+						}
+					})
+				case ']':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetStereoSeparation(settings.StereoSeparation + 10)
+					})
+				case 'X', 'x':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetCrossfeed(!settings.Crossfeed)
+					})
+				case '-':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetMasterVolume(settings.MasterVolume - 0.1)
+					})
+				case '=', '+':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetMasterVolume(settings.MasterVolume + 0.1)
+					})
 				case '1', '2', '3', '4', '5', '6', '7', '8':
 					channelNumber, err := strconv.Atoi(string(rune))
 					channelNumber--
-					if err == nil && channelNumber < len(player.State.Channels) {
-						player.State.Channels[channelNumber].Muted = !player.State.Channels[channelNumber].Muted
+					if err == nil {
+						player.ToggleMute(channelNumber)
 					}
+				case '/':
+					player.ToggleSolo(int(atomic.LoadInt32(&selectedChannel)))
+				case '0':
+					player.UnmuteAll()
 				case 's', 'S':
-					if player.Standard == "NTSC" {
-						player.Standard = "PAL"
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						if settings.Standard == mod.NTSC {
+							player.SetStandard(mod.PAL)
+						} else {
+							player.SetStandard(mod.NTSC)
+						}
+					})
+				case 'c', 'C':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetCompatibility((settings.Compatibility + 1) % (mod.ImpulseTrackerProfile + 1))
+					})
+				case 'v', 'V':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetVBlankTiming(!settings.VBlankTiming)
+					})
+				case 'o', 'O':
+					togglePanel(scopesPanel)
+				case 'a', 'A':
+					togglePanel(spectrumPanel)
+				case 'w', 'W':
+					togglePanel(waveformPanel)
+				case 'u', 'U':
+					changeQueue(func() {
+						queue.SetShuffle(!queue.Shuffle())
+					})
+				case 'r', 'R':
+					changeQueue(func() {
+						queue.SetRepeat((queue.Repeat() + 1) % (playlist.RepeatAll + 1))
+					})
+				case 'f', 'F':
+					path := nowPlaying.path()
+					store.SetFavourite(path, !store.Get(path).Favourite)
+				case '*':
+					path := nowPlaying.path()
+					store.SetRating(path, (store.Get(path).Rating+1)%(userdata.MaxRating+1))
+				case ' ':
+					player.SetPaused(!player.Paused())
+				case ',', '.':
+					// Step from the order being heard, which the pattern
+					// view shows, rather than the one being mixed
+					order := int(heardSnapshot(player, sp, sampleRate).Order)
+					if rune == ',' {
+						order--
+					} else {
+						order++
+					}
+					if order < 0 {
+						player.Restart()
 					} else {
-						player.Standard = "NTSC"
+						player.Seek(uint32(order))
 					}
+				case 't':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetTempoScale(settings.TempoScale - tempoStep)
+					})
+				case 'T':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetTempoScale(settings.TempoScale + tempoStep)
+					})
+				case 'p':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetPitchShift(settings.PitchShift - 1)
+					})
+				case 'P':
+					nowPlaying.changeSettings(func(player *mod.Player, settings mod.Settings) {
+						player.SetPitchShift(settings.PitchShift + 1)
+					})
 				case 'q', 'Q':
 					quit()
 				}
@@ -0,0 +1,372 @@
+package library
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeozeozeo/gomodplay/pkg/mod"
+)
+
+// cacheVersion is bumped whenever Entry changes, dropping older caches
+const cacheVersion = 1
+
+// maxScanDepth is how many levels of subdirectories Scan goes into, so
+// scanning the root or a home directory doesn't walk the whole tree
+const maxScanDepth = 2
+
+// Entry is what the library knows about one module. Durations are in
+// seconds
+type Entry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Hash     string    `json:"hash"`
+	Title    string    `json:"title"`
+	Format   string    `json:"format"`
+	Channels int       `json:"channels"`
+	Duration float64   `json:"duration"`
+	// Err is set when the file couldn't be loaded as a module
+	Err string `json:"error,omitempty"`
+}
+
+type cacheFile struct {
+	Version int      `json:"version"`
+	Entries []*Entry `json:"entries"`
+}
+
+// Library scans directories for modules in the background and remembers
+// their metadata in an on-disk cache, so each file is only loaded once
+// until it changes
+type Library struct {
+	cachePath string
+	match     func(name string) bool
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	byHash  map[string]*Entry
+	// scanned holds how many levels below each directory were queued
+	// when it was scanned
+	scanned map[string]int
+	// pending is a stack of directories to scan, the newest on top
+	pending []scanJob
+	// cancels counts calls to Cancel, stopping the directory being scanned
+	cancels int
+	dirty   bool
+	saveErr error
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// DefaultCachePath returns where the cache lives in the user's cache
+// directory, or "" when there is none
+func DefaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gomodplay", "library.json")
+}
+
+// New returns a library that scans the files match accepts and keeps its
+// cache at cachePath, "" for none. A missing or unreadable cache is rebuilt
+func New(cachePath string, match func(name string) bool) *Library {
+	l := &Library{
+		cachePath: cachePath,
+		match:     match,
+		entries:   make(map[string]*Entry),
+		byHash:    make(map[string]*Entry),
+		scanned:   make(map[string]int),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	l.loadCache()
+	go l.run()
+	return l
+}
+
+// Close stops scanning and writes the cache
+func (l *Library) Close() error {
+	close(l.done)
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.saveCache(); err != nil {
+		return err
+	}
+	return l.saveErr
+}
+
+// scanJob is a directory waiting to be scanned, depth levels below the one
+// Scan was called with
+type scanJob struct {
+	dir   string
+	depth int
+}
+
+// Scan queues dir to be scanned, ahead of directories queued before it.
+// Subdirectories down to maxScanDepth levels are scanned the first time a
+// directory is
+func (l *Library) Scan(dir string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	l.pending = append(l.pending, scanJob{dir, 0})
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Cancel drops the directories waiting to be scanned and stops the one
+// being scanned, for when they are no longer wanted
+func (l *Library) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = nil
+	l.cancels++
+}
+
+// Lookup returns what is known about the module at path
+func (l *Library) Lookup(path string) (Entry, bool) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Entry{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[path]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+func (l *Library) run() {
+	defer close(l.stopped)
+	for {
+		l.mu.Lock()
+		var job scanJob
+		if len(l.pending) > 0 {
+			job = l.pending[len(l.pending)-1]
+			l.pending = l.pending[:len(l.pending)-1]
+		} else if l.dirty {
+			l.saveErr = l.saveCache()
+		}
+		cancels := l.cancels
+		l.mu.Unlock()
+
+		if job.dir != "" {
+			l.scanDir(job, cancels)
+			continue
+		}
+		select {
+		case <-l.wake:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// scanDir scans the modules directly inside a directory, then queues the
+// subdirectories that haven't been scanned as deep before. It stops if
+// Cancel is called after cancels calls
+func (l *Library) scanDir(job scanJob, cancels int) {
+	dir := job.dir
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	present := make(map[string]bool)
+	var subdirs []string
+	for _, item := range items {
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+		if l.cancelled(cancels) {
+			return
+		}
+		path := filepath.Join(dir, item.Name())
+		if item.IsDir() {
+			subdirs = append(subdirs, path)
+			continue
+		}
+		if !l.match(item.Name()) {
+			continue
+		}
+		present[path] = true
+		l.scanFile(path)
+	}
+	l.forgetMissing(dir, present)
+
+	// Subdirectories dropped by Cancel are queued again on the next visit
+	levels := maxScanDepth - job.depth
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if previous, seen := l.scanned[dir]; !seen || levels > previous {
+		l.scanned[dir] = levels
+	}
+	if levels > 0 {
+		for idx := len(subdirs) - 1; idx >= 0; idx-- {
+			if previous, seen := l.scanned[subdirs[idx]]; !seen || previous < levels-1 {
+				l.pending = append(l.pending, scanJob{subdirs[idx], job.depth + 1})
+			}
+		}
+	}
+}
+
+// cancelled reports whether Cancel was called since there were cancels
+// calls
+func (l *Library) cancelled(cancels int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cancels != cancels
+}
+
+// scanFile brings the entry of one file up to date, loading it only when
+// its contents aren't known yet
+func (l *Library) scanFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	cached, ok := l.entries[path]
+	unchanged := ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime())
+	l.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+
+	l.mu.Lock()
+	known, found := l.byHash[hash]
+	l.mu.Unlock()
+
+	entry := &Entry{}
+	if found {
+		*entry = *known
+	} else {
+		metadata, err := readMetadata(data)
+		if err != nil {
+			entry.Err = err.Error()
+		}
+		entry.Title = metadata.Title
+		entry.Format = metadata.Format
+		entry.Channels = metadata.Channels
+		entry.Duration = metadata.Duration
+	}
+	entry.Path = path
+	entry.Size = info.Size()
+	entry.ModTime = info.ModTime()
+	entry.Hash = hash
+
+	l.mu.Lock()
+	l.entries[path] = entry
+	l.byHash[hash] = entry
+	l.dirty = true
+	l.mu.Unlock()
+}
+
+// forgetMissing drops the entries of files in dir that are gone
+func (l *Library) forgetMissing(dir string, present map[string]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for path, entry := range l.entries {
+		if filepath.Dir(path) == dir && !present[path] {
+			delete(l.entries, path)
+			if l.byHash[entry.Hash] == entry {
+				delete(l.byHash, entry.Hash)
+			}
+			l.dirty = true
+		}
+	}
+}
+
+// readMetadata loads a module, turning a panic on a malformed file into
+// an error
+func readMetadata(data []byte) (metadata mod.Metadata, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed module: %v", r)
+		}
+	}()
+	player := mod.NewModPlayer(48000)
+	if err := player.LoadModFile(bytes.NewReader(data)); err != nil {
+		return mod.Metadata{}, err
+	}
+	return player.Metadata(), nil
+}
+
+func (l *Library) loadCache() {
+	if l.cachePath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(l.cachePath)
+	if err != nil {
+		return
+	}
+	var cache cacheFile
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Version != cacheVersion {
+		return
+	}
+	for _, entry := range cache.Entries {
+		l.entries[entry.Path] = entry
+		l.byHash[entry.Hash] = entry
+	}
+}
+
+// saveCache writes the cache through a temporary file, so a crash never
+// leaves half of it behind
+func (l *Library) saveCache() error {
+	l.dirty = false
+	if l.cachePath == "" {
+		return nil
+	}
+	cache := cacheFile{
+		Version: cacheVersion,
+		Entries: make([]*Entry, 0, len(l.entries)),
+	}
+	for _, entry := range l.entries {
+		cache.Entries = append(cache.Entries, entry)
+	}
+	sort.Slice(cache.Entries, func(i, j int) bool {
+		return cache.Entries[i].Path < cache.Entries[j].Path
+	})
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.cachePath), 0755); err != nil {
+		return err
+	}
+	tmp := l.cachePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.cachePath)
+}
@@ -0,0 +1,264 @@
+package library
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func isModule(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".mod")
+}
+
+// readModule returns one of the repository's example modules
+func readModule(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("..", "..", "modfiles", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newIdle returns a library without its background scanner, so a test can
+// run the scans itself with drain
+func newIdle(match func(name string) bool) *Library {
+	return &Library{
+		match:   match,
+		entries: make(map[string]*Entry),
+		byHash:  make(map[string]*Entry),
+		scanned: make(map[string]int),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// drain scans everything queued, the way the background scanner does
+func drain(l *Library) {
+	for {
+		l.mu.Lock()
+		if len(l.pending) == 0 {
+			l.mu.Unlock()
+			return
+		}
+		job := l.pending[len(l.pending)-1]
+		l.pending = l.pending[:len(l.pending)-1]
+		cancels := l.cancels
+		l.mu.Unlock()
+		l.scanDir(job, cancels)
+	}
+}
+
+func TestScanFile(t *testing.T) {
+	axel, aurora := readModule(t, "AXELF.MOD"), readModule(t, "aurora.mod")
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// change rewrites the file after its first scan
+		change func(t *testing.T, path string)
+		title  string
+		err    bool
+	}{
+		{"unchanged", func(t *testing.T, path string) {}, "axel f", false},
+		{
+			"rewritten", func(t *testing.T, path string) {
+				writeFile(t, path, aurora)
+			}, "aurora", false,
+		},
+		{
+			// Files are only loaded again when their size or time changes
+			"same size and time", func(t *testing.T, path string) {
+				data := append([]byte("renamed"), axel[7:]...)
+				writeFile(t, path, data)
+				os.Chtimes(path, modTime, modTime)
+			}, "axel f", false,
+		},
+		{
+			"touched", func(t *testing.T, path string) {
+				data := append([]byte("renamed"), axel[7:]...)
+				writeFile(t, path, data)
+			}, "renamed", false,
+		},
+		{
+			"malformed", func(t *testing.T, path string) {
+				writeFile(t, path, []byte("not a module"))
+			}, "", true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "song.mod")
+			writeFile(t, path, axel)
+			os.Chtimes(path, modTime, modTime)
+			l := newIdle(isModule)
+			l.scanFile(path)
+			test.change(t, path)
+			l.scanFile(path)
+
+			entry, ok := l.Lookup(path)
+			if !ok {
+				t.Fatal("no entry")
+			}
+			if entry.Title != test.title || (entry.Err != "") != test.err {
+				t.Errorf("got title %q and error %q, want %q", entry.Title, entry.Err, test.title)
+			}
+			info, _ := os.Stat(path)
+			if entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+				t.Errorf("entry is of size %d from %v, file of %d from %v", entry.Size, entry.ModTime, info.Size(), info.ModTime())
+			}
+		})
+	}
+}
+
+func TestScanCopies(t *testing.T) {
+	dir := t.TempDir()
+	data := readModule(t, "aurora.mod")
+	first, second := filepath.Join(dir, "a.mod"), filepath.Join(dir, "b.mod")
+	writeFile(t, first, data)
+	writeFile(t, second, data)
+
+	l := newIdle(isModule)
+	l.scanFile(first)
+	// A copy is known by its hash, so its metadata is taken from the first
+	l.entries[first].Title = "from the cache"
+	l.scanFile(second)
+	entry, _ := l.Lookup(second)
+	if entry.Title != "from the cache" || entry.Path != second {
+		t.Errorf("copy has title %q and path %q", entry.Title, entry.Path)
+	}
+
+	os.Remove(first)
+	l.scanDir(scanJob{dir: dir}, 0)
+	if _, ok := l.Lookup(first); ok {
+		t.Error("removed file is still known")
+	}
+	if _, ok := l.Lookup(second); !ok {
+		t.Error("copy was dropped with the removed file")
+	}
+}
+
+func TestScanDepth(t *testing.T) {
+	root := t.TempDir()
+	data := readModule(t, "aurora.mod")
+	dirs := []string{"", "a", "a/b", "a/b/c", "a/b/c/d", "e"}
+	for _, dir := range dirs {
+		writeFile(t, filepath.Join(root, filepath.FromSlash(dir), "song.mod"), data)
+	}
+	tests := []struct {
+		name  string
+		scans []string
+		want  []string
+	}{
+		{"root", []string{""}, []string{"", "a", "a/b", "e"}},
+		{"deeper", []string{"a/b"}, []string{"a/b", "a/b/c", "a/b/c/d"}},
+		{"root then deeper", []string{"", "a"}, []string{"", "a", "a/b", "a/b/c", "e"}},
+		{"leaf", []string{"e"}, []string{"e"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newIdle(isModule)
+			for _, dir := range test.scans {
+				l.Scan(filepath.Join(root, filepath.FromSlash(dir)))
+				drain(l)
+			}
+			var got []string
+			for _, dir := range dirs {
+				if _, ok := l.Lookup(filepath.Join(root, filepath.FromSlash(dir), "song.mod")); ok {
+					got = append(got, dir)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("scanned %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	root := t.TempDir()
+	data := readModule(t, "aurora.mod")
+	for _, name := range []string{"a.mod", "b.mod", "sub/c.mod"} {
+		writeFile(t, filepath.Join(root, filepath.FromSlash(name)), data)
+	}
+
+	var l *Library
+	l = newIdle(func(name string) bool {
+		// Cancelled as soon as the first file is reached
+		if name == "a.mod" {
+			l.Cancel()
+		}
+		return isModule(name)
+	})
+	l.Scan(root)
+	l.Scan(filepath.Join(root, "sub"))
+	l.Cancel()
+	drain(l)
+	if len(l.entries) != 0 {
+		t.Fatalf("got %d entries after cancelling, want none", len(l.entries))
+	}
+
+	l.Scan(root)
+	drain(l)
+	if _, ok := l.Lookup(filepath.Join(root, "b.mod")); ok {
+		t.Error("scan carried on after it was cancelled")
+	}
+
+	// The cancelled directory is scanned in full on the next visit
+	l.match = isModule
+	l.Scan(root)
+	drain(l)
+	if len(l.entries) != 3 {
+		t.Errorf("got %d entries, want 3", len(l.entries))
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache", "library.json")
+	path := filepath.Join(dir, "song.mod")
+	writeFile(t, path, readModule(t, "aurora.mod"))
+
+	l := New(cachePath, isModule)
+	l.Scan(dir)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := l.Lookup(path); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the scan didn't finish")
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := New(cachePath, isModule)
+	defer reopened.Close()
+	if entry, ok := reopened.Lookup(path); !ok || entry.Title != "aurora" {
+		t.Errorf("got %+v from the cache", entry)
+	}
+
+	// A cache from another version is dropped
+	writeFile(t, cachePath, []byte(fmt.Sprintf(`{"version":0,"entries":[{"path":%q,"hash":"x"}]}`, path)))
+	old := New(cachePath, isModule)
+	defer old.Close()
+	if _, ok := old.Lookup(path); ok {
+		t.Error("entry read from an old cache")
+	}
+}
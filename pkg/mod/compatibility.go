@@ -0,0 +1,132 @@
+package mod
+
+// CompatibilityProfile selects which tracker's playback quirks are emulated
+type CompatibilityProfile int
+
+const (
+	// ClassicProfile plays mods the way this player always has, and is the
+	// default
+	ClassicProfile CompatibilityProfile = iota
+	// ProTracker1Profile treats every Fxx as a speed change
+	ProTracker1Profile
+	// ProTracker2Profile is the reference most mods were written against
+	ProTracker2Profile
+	// ProTracker3Profile drops the ProTracker 2 sample swap
+	ProTracker3Profile
+	// FastTracker2Profile adds slide memory and plays mods like FT2
+	FastTracker2Profile
+	// ImpulseTrackerProfile shares portamento memory and switches samples
+	// immediately, like IT playing a mod
+	ImpulseTrackerProfile
+)
+
+func (profile CompatibilityProfile) String() string {
+	return [...]string{"Classic", "ProTracker 1", "ProTracker 2", "ProTracker 3", "FastTracker 2", "Impulse Tracker"}[profile]
+}
+
+// sampleSwap is what a sample number without a note does
+type sampleSwap int
+
+const (
+	// swapImmediately switches to the new sample at the current position
+	swapImmediately sampleSwap = iota
+	// swapAtLoopEnd switches once the playing sample reaches its end
+	swapAtLoopEnd
+	// swapVolumeOnly only resets the volume, the old sample keeps playing
+	swapVolumeOnly
+)
+
+// quirks are the individual behaviours that differ between trackers
+type quirks struct {
+	sampleSwap sampleSwap
+	// loopStartAfterLoop moves the E6x loop start past a finished loop
+	loopStartAfterLoop bool
+	// forgetLoopStart clears the E6x loop start once a loop finishes
+	forgetLoopStart bool
+	// slideMemory makes 1xx and 2xx with a zero argument reuse the last speed
+	slideMemory bool
+	// sharedPortaMemory makes 1xx, 2xx and 3xx share one speed memory
+	sharedPortaMemory bool
+	// speedOnly treats Fxx of 32 and above as speed rather than tempo
+	speedOnly bool
+	// tonePortaRestartsSample starts a new sample given with 3xx rather
+	// than gliding the playing one
+	tonePortaRestartsSample bool
+	// portaSwapsSample glides a new sample given with 3xx or 5xx from where
+	// the note left it, 5xx keeping the playing position
+	portaSwapsSample bool
+}
+
+var profileQuirks = map[CompatibilityProfile]quirks{
+	ClassicProfile: {
+		sampleSwap:       swapImmediately,
+		forgetLoopStart:  true,
+		portaSwapsSample: true,
+	},
+	ProTracker1Profile: {
+		sampleSwap: swapImmediately,
+		speedOnly:  true,
+	},
+	ProTracker2Profile: {
+		sampleSwap: swapAtLoopEnd,
+	},
+	ProTracker3Profile: {
+		sampleSwap: swapVolumeOnly,
+	},
+	FastTracker2Profile: {
+		sampleSwap:  swapVolumeOnly,
+		slideMemory: true,
+	},
+	ImpulseTrackerProfile: {
+		sampleSwap:              swapImmediately,
+		loopStartAfterLoop:      true,
+		slideMemory:             true,
+		sharedPortaMemory:       true,
+		tonePortaRestartsSample: true,
+	},
+}
+
+func (p *Player) quirks() quirks {
+	return profileQuirks[p.Compatibility]
+}
+
+// slideSpeed returns the speed of a 1xx or 2xx slide, filling in a zero
+// argument from the slide memory when the profile has one
+func (p *Player) slideSpeed(channel *ChannelInfo, argument uint8) int32 {
+	q := p.quirks()
+	memory := &channel.lastSlideSpeed
+	if q.sharedPortaMemory {
+		memory = &channel.lastPortaSpeed
+	}
+	if argument != 0 {
+		*memory = int32(argument)
+		return *memory
+	}
+	if q.slideMemory {
+		return *memory
+	}
+	return 0
+}
+
+// keepPortamentoSample decides which sample a tone portamento glides. A new
+// sample either restarts or only brings its volume to the playing one.
+// continues is set for 5xx, which carries on the last portamento
+func (p *Player) keepPortamentoSample(channel *ChannelInfo, oldValues *ChannelInfo, continues bool) {
+	q := p.quirks()
+	if oldValues.SampleNum == channel.SampleNum || (continues && q.portaSwapsSample) {
+		channel.samplePos = oldValues.samplePos
+		return
+	}
+	if q.portaSwapsSample {
+		return
+	}
+	if q.tonePortaRestartsSample || oldValues.SampleNum == 0 {
+		// Without a period the old voice's offset is still there
+		channel.samplePos = 0
+		return
+	}
+	channel.SampleNum = oldValues.SampleNum
+	channel.size = oldValues.size
+	channel.fineTune = oldValues.fineTune
+	channel.samplePos = oldValues.samplePos
+}
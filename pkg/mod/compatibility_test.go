@@ -0,0 +1,141 @@
+package mod
+
+import "testing"
+
+// channelAt plays the song for samples samples and returns channel 0
+func channelAt(p *Player, samples int) ChannelSnapshot {
+	p.Stream(make([][2]float32, samples))
+	return p.Snapshot().Channels[0]
+}
+
+func TestSampleSwap(t *testing.T) {
+	// The long sample plays until about 24700 samples in
+	swap := []cell{
+		{sample: 2, period: 428},
+		{row: 1, sample: 1},
+	}
+	regiven := append(swap, cell{row: 2, sample: 2})
+	tests := []struct {
+		profile CompatibilityProfile
+		cells   []cell
+		// The sample playing in the note and after the long sample's end
+		during, after uint8
+	}{
+		{ClassicProfile, swap, 1, 1},
+		{ProTracker1Profile, swap, 1, 1},
+		{ProTracker2Profile, swap, 2, 1},
+		{ProTracker2Profile, regiven, 2, 2},
+		{ProTracker3Profile, swap, 2, 2},
+		{FastTracker2Profile, swap, 2, 2},
+		{ImpulseTrackerProfile, swap, 1, 1},
+	}
+	for _, test := range tests {
+		p := loadSong(t, buildSong([]uint8{0}, 1, test.cells...))
+		p.SetCompatibility(test.profile)
+		if got := channelAt(p, 2*testRowSamples+1000).SampleNum; got != test.during {
+			t.Errorf("%v with %d cells plays sample %d in the note, want %d", test.profile, len(test.cells), got, test.during)
+		}
+		if got := channelAt(p, 2*testRowSamples).SampleNum; got != test.after {
+			t.Errorf("%v with %d cells plays sample %d after the loop, want %d", test.profile, len(test.cells), got, test.after)
+		}
+	}
+}
+
+func TestPatternLoopStart(t *testing.T) {
+	// Rows 2 to 4 play twice, then row 6 loops again without setting a start
+	cells := []cell{
+		{row: 2, effect: 0xe, argument: 0x60},
+		{row: 4, effect: 0xe, argument: 0x61},
+		{row: 6, effect: 0xe, argument: 0x61},
+	}
+	tests := []struct {
+		profile CompatibilityProfile
+		rows    uint64
+	}{
+		// The loop start is forgotten, so the second loop does nothing
+		{ClassicProfile, 64 + 3},
+		// The loop start moves past the first loop, to row 5
+		{ImpulseTrackerProfile, 64 + 3 + 2},
+	}
+	for _, test := range tests {
+		p := loadSong(t, buildSong([]uint8{0}, 1, cells...))
+		p.SetCompatibility(test.profile)
+		if got := p.Length() / testRowSamples; got != test.rows {
+			t.Errorf("%v plays %d rows, want %d", test.profile, got, test.rows)
+		}
+	}
+}
+
+func TestSpeedOrTempo(t *testing.T) {
+	tests := []struct {
+		profile      CompatibilityProfile
+		speed, tempo uint32
+	}{
+		{ClassicProfile, 6, 64},
+		{ProTracker1Profile, 64, defaultTempo},
+		{ProTracker2Profile, 6, 64},
+	}
+	for _, test := range tests {
+		p := loadSong(t, buildSong([]uint8{0}, 1, cell{effect: 0xf, argument: 64}))
+		p.SetCompatibility(test.profile)
+		p.Stream(make([][2]float32, 1))
+		if s := p.Snapshot(); s.Speed != test.speed || s.Tempo != test.tempo {
+			t.Errorf("%v: got speed %d and tempo %d, want %d and %d", test.profile, s.Speed, s.Tempo, test.speed, test.tempo)
+		}
+	}
+}
+
+func TestSlideMemory(t *testing.T) {
+	cells := []cell{
+		{sample: 1, period: 428, effect: 0x1, argument: 4},
+		{row: 1, effect: 0x1},
+	}
+	tests := []struct {
+		profile CompatibilityProfile
+		memory  bool
+	}{
+		{ClassicProfile, false},
+		{ProTracker2Profile, false},
+		{FastTracker2Profile, true},
+		{ImpulseTrackerProfile, true},
+	}
+	for _, test := range tests {
+		p := loadSong(t, buildSong([]uint8{0}, 1, cells...))
+		p.SetCompatibility(test.profile)
+		start := channelAt(p, testRowSamples+1).Period
+		end := channelAt(p, testRowSamples-1).Period
+		if slid := end < start; slid != test.memory {
+			t.Errorf("%v: 100 slid from %d to %d, want sliding %v", test.profile, start, end, test.memory)
+		}
+	}
+}
+
+func TestPortamentoNewSample(t *testing.T) {
+	tests := []struct {
+		profile CompatibilityProfile
+		effect  uint8
+		sample  uint8
+		// restarted is set when the sample plays from its start
+		restarted bool
+	}{
+		{ClassicProfile, 0x3, 2, true},
+		{ClassicProfile, 0x5, 2, false},
+		{ProTracker2Profile, 0x3, 1, false},
+		{ProTracker2Profile, 0x5, 1, false},
+		{ImpulseTrackerProfile, 0x3, 2, true},
+		{ImpulseTrackerProfile, 0x5, 2, true},
+	}
+	for _, test := range tests {
+		cells := []cell{
+			{sample: 1, period: 428},
+			{row: 1, sample: 2, period: 214, effect: test.effect, argument: 4},
+		}
+		p := loadSong(t, buildSong([]uint8{0}, 1, cells...))
+		p.SetCompatibility(test.profile)
+		channel := channelAt(p, testRowSamples+1)
+		if restarted := channel.SamplePosition < 1; channel.SampleNum != test.sample || restarted != test.restarted {
+			t.Errorf("%v with %X: sample %d at %v, want sample %d restarted %v", test.profile, test.effect,
+				channel.SampleNum, channel.SamplePosition, test.sample, test.restarted)
+		}
+	}
+}
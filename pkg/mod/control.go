@@ -0,0 +1,316 @@
+package mod
+
+import "time"
+
+// View calls fn with the player locked, so everything it reads comes from
+// the same point in playback. fn must not call other Player methods
+func (p *Player) View(fn func(p *Player)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(p)
+}
+
+// SetStandard switches the Amiga clock used for pitch, and with
+// VBlankTiming also the tick rate, taking effect from the next sample
+func (p *Player) SetStandard(standard Standard) {
+	p.changeTiming(func() {
+		p.setStandard(standard)
+	})
+}
+
+// SetVBlankTiming chooses whether songs that never set a tempo tick at the
+// vBlank rate of the standard rather than the 50 Hz default tempo
+func (p *Player) SetVBlankTiming(enabled bool) {
+	p.changeTiming(func() {
+		p.VBlankTiming = enabled
+		p.updateTiming()
+	})
+}
+
+// SetMixingMode switches to one of the mixing presets
+func (p *Player) SetMixingMode(mode MixingMode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setMixingMode(mode)
+}
+
+// SetStereoSeparation sets the stereo separation in percent, where 0 is mono
+// and 100 is hard Amiga panning. Values up to 200 are taken, but play as 100
+// as the Amiga's channels can't be panned any harder
+func (p *Player) SetStereoSeparation(percent uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if percent > maxStereoSeparation {
+		percent = maxStereoSeparation
+	}
+	p.MixingMode = CustomMixingMode
+	p.StereoSeparation = percent
+}
+
+// SetCrossfeed turns the headphone crossfeed on or off
+func (p *Player) SetCrossfeed(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Crossfeed = enabled
+}
+
+// SetMasterVolume sets the output gain, where 1 leaves the mix unchanged
+func (p *Player) SetMasterVolume(volume float32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if volume < 0 {
+		volume = 0
+	}
+	p.MasterVolume = volume
+}
+
+// SetLimiter turns the output limiter on or off
+func (p *Player) SetLimiter(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Limiter = enabled
+}
+
+// SetCompatibility switches the tracker quirks playback follows
+func (p *Player) SetCompatibility(profile CompatibilityProfile) {
+	if _, ok := profileQuirks[profile]; !ok {
+		return
+	}
+	p.changeTiming(func() {
+		p.Compatibility = profile
+	})
+}
+
+// SetInterpolation switches how samples are resampled
+func (p *Player) SetInterpolation(interpolation Interpolation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if interpolation >= NoInterpolation && interpolation <= LinearInterpolation {
+		p.Interpolation = interpolation
+	}
+}
+
+// SetLoopCount sets how many more times the song plays, LoopForever to
+// never stop
+func (p *Player) SetLoopCount(count int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if count >= LoopForever {
+		p.LoopCount = count
+	}
+}
+
+// Seek jumps to the start of a position in the song's order list. Positions
+// the song reaches from the start pick up the speed and tempo set by then
+func (p *Player) Seek(position uint32) {
+	p.mu.Lock()
+	if p.State == nil || position >= p.Song.NumUsedPatterns {
+		p.mu.Unlock()
+		return
+	}
+	samples, ok := p.orderTime(position)
+	p.mu.Unlock()
+	if ok && p.seekTime(samples) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State != nil {
+		p.seek(position)
+	}
+}
+
+// SeekTime jumps to the row playing at position into the song, returning
+// false if the song is shorter than that
+func (p *Player) SeekTime(position time.Duration) bool {
+	if position < 0 {
+		position = 0
+	}
+	p.mu.Lock()
+	target := uint64(position.Seconds() * float64(p.SampleRate))
+	p.mu.Unlock()
+	return p.seekTime(target)
+}
+
+// Restart plays the song again from the start, as if it was just loaded
+func (p *Player) Restart() {
+	if !p.seekTime(0) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.State.loops = 0
+	p.State.HasLooped = false
+}
+
+// SetPaused pauses or resumes playback. A paused player streams silence
+func (p *Player) SetPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.SongPlaying = !paused
+}
+
+// Paused reports whether playback is paused
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.SongPlaying
+}
+
+// SetTempoScale sets how much faster than the song's own tempo it plays,
+// between 0.25 and 4, without changing the pitch
+func (p *Player) SetTempoScale(scale float32) {
+	if scale < minTempoScale {
+		scale = minTempoScale
+	}
+	if scale > maxTempoScale {
+		scale = maxTempoScale
+	}
+	p.changeTiming(func() {
+		// Keep the position at the same point in the song, which now takes
+		// a different number of samples to get to
+		if p.State != nil {
+			p.State.songPosition = uint64(float64(p.State.songPosition) * float64(p.tempoScale()) / float64(scale))
+		}
+		p.TempoScale = scale
+		p.updateTiming()
+	})
+}
+
+// SetPitchShift transposes playback by up to 12 semitones either way,
+// without changing the tempo
+func (p *Player) SetPitchShift(semitones int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if semitones < -maxPitchShift {
+		semitones = -maxPitchShift
+	}
+	if semitones > maxPitchShift {
+		semitones = maxPitchShift
+	}
+	p.PitchShift = semitones
+	p.setStandard(p.Standard)
+}
+
+// seek makes the next tick play the first row of position
+func (p *Player) seek(position uint32) {
+	ps := p.State
+	ps.NextPosition = int32(position)
+	ps.NextPatternPosition = -1
+	ps.PatternLoop = 0
+	ps.PatternLoopPosition = nil
+	ps.SetPatternPosition = false
+	ps.jumpingBack = false
+	ps.DelayLine = 0
+	ps.SongHasEnded = false
+	// Positions the song doesn't reach from the start, like a subsong,
+	// have no place in its timeline and count from 0
+	ps.songPosition = 0
+	ps.startFirstRow()
+}
+
+// SetSampleRate changes the output sample rate, keeping the pitch, tempo,
+// the duration of the volume ramp and how far into the song playback is
+func (p *Player) SetSampleRate(sampleRate uint32) {
+	p.mu.Lock()
+	unchanged := sampleRate == 0 || sampleRate == p.SampleRate
+	p.mu.Unlock()
+	if unchanged {
+		return
+	}
+	p.changeTiming(func() {
+		// rescale converts a count of samples at the old rate to the new one
+		rescale := func(samples uint64) uint64 {
+			return samples * uint64(sampleRate) / uint64(p.SampleRate)
+		}
+		if ps := p.State; ps != nil {
+			ps.CurrentVBlankSample = uint32(rescale(uint64(ps.CurrentVBlankSample)))
+			// Positions are counted in output samples too, the row
+			// history has to move with them for RowAt
+			ps.samplePosition = rescale(ps.samplePosition)
+			ps.songPosition = rescale(ps.songPosition)
+			for idx := range ps.rowHistory {
+				ps.rowHistory[idx].samplePosition = rescale(ps.rowHistory[idx].samplePosition)
+			}
+		}
+		p.VolumeRamp = uint32(rescale(uint64(p.VolumeRamp)))
+		p.SampleRate = sampleRate
+		p.setStandard(p.Standard)
+	})
+}
+
+// Settings are the playback options that carry over from song to song
+type Settings struct {
+	MixingMode       MixingMode
+	StereoSeparation uint32
+	Crossfeed        bool
+	MasterVolume     float32
+	AutoHeadroom     bool
+	Limiter          bool
+	Standard         Standard
+	VBlankTiming     bool
+	AutoStandard     bool
+	Compatibility    CompatibilityProfile
+	Interpolation    Interpolation
+	LoopCount        int
+	TempoScale       float32
+	PitchShift       int
+	VolumeRamp       uint32
+}
+
+// Settings returns the player's current playback options
+func (p *Player) Settings() Settings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Settings{
+		MixingMode:       p.MixingMode,
+		StereoSeparation: p.StereoSeparation,
+		Crossfeed:        p.Crossfeed,
+		MasterVolume:     p.MasterVolume,
+		AutoHeadroom:     p.AutoHeadroom,
+		Limiter:          p.Limiter,
+		Standard:         p.Standard,
+		VBlankTiming:     p.VBlankTiming,
+		AutoStandard:     p.AutoStandard,
+		Compatibility:    p.Compatibility,
+		Interpolation:    p.Interpolation,
+		LoopCount:        p.LoopCount,
+		TempoScale:       p.TempoScale,
+		PitchShift:       p.PitchShift,
+		VolumeRamp:       p.VolumeRamp,
+	}
+}
+
+// ApplySettings copies playback options from another player. With
+// AutoStandard the loaded song's own hint, or PAL without one, still wins
+// over the Standard
+func (p *Player) ApplySettings(settings Settings) {
+	p.changeTiming(func() {
+		p.applySettings(settings)
+	})
+}
+
+// applySettings is ApplySettings with the player locked
+func (p *Player) applySettings(settings Settings) {
+	p.MixingMode = settings.MixingMode
+	p.StereoSeparation = settings.StereoSeparation
+	p.Crossfeed = settings.Crossfeed
+	p.MasterVolume = settings.MasterVolume
+	p.AutoHeadroom = settings.AutoHeadroom
+	p.Limiter = settings.Limiter
+	p.VBlankTiming = settings.VBlankTiming
+	p.AutoStandard = settings.AutoStandard
+	p.Compatibility = settings.Compatibility
+	p.Interpolation = settings.Interpolation
+	p.LoopCount = settings.LoopCount
+	p.TempoScale = settings.TempoScale
+	p.PitchShift = settings.PitchShift
+	p.VolumeRamp = settings.VolumeRamp
+
+	standard := settings.Standard
+	if p.SongLoaded && p.AutoStandard {
+		standard = p.Song.autoStandard()
+	}
+	p.setStandard(standard)
+}
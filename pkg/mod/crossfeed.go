@@ -0,0 +1,30 @@
+package mod
+
+import "math"
+
+const (
+	crossfeedCutoff = 700
+	crossfeedLevel  = 0.4
+)
+
+// crossfeed bleeds a low passed copy of each side into the other, so hard
+// panned Amiga music sounds less tiring on headphones
+type crossfeed struct {
+	sampleRate uint32
+	coeff      float32
+	left       float32
+	right      float32
+}
+
+func (c *crossfeed) process(left float32, right float32, sampleRate uint32) (float32, float32) {
+	if c.sampleRate != sampleRate {
+		c.sampleRate = sampleRate
+		c.coeff = float32(1 - math.Exp(-2*math.Pi*crossfeedCutoff/float64(sampleRate)))
+	}
+	c.left += (left - c.left) * c.coeff
+	c.right += (right - c.right) * c.coeff
+
+	outLeft := (left + c.right*crossfeedLevel) / (1 + crossfeedLevel)
+	outRight := (right + c.left*crossfeedLevel) / (1 + crossfeedLevel)
+	return outLeft, outRight
+}
@@ -0,0 +1,29 @@
+package mod
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCrossfeed(t *testing.T) {
+	// A steady tone on the left bleeds into the right in full
+	var c crossfeed
+	var left, right float32
+	for i := 0; i < testSampleRate/10; i++ {
+		left, right = c.process(1, 0, testSampleRate)
+	}
+	if !near(left, 1/(1+crossfeedLevel)) || !near(right, crossfeedLevel/(1+crossfeedLevel)) {
+		t.Errorf("steady left tone gave %v and %v", left, right)
+	}
+
+	// High frequencies barely bleed at all
+	c = crossfeed{}
+	var loudest float64
+	for i := 0; i < testSampleRate/10; i++ {
+		_, right = c.process(float32(1-2*(i%2)), 0, testSampleRate)
+		loudest = math.Max(loudest, math.Abs(float64(right)))
+	}
+	if loudest > 0.05 {
+		t.Errorf("a tone at half the sample rate bled through at %v", loudest)
+	}
+}
@@ -0,0 +1,196 @@
+package mod
+
+import "time"
+
+// maxSimulatedTicks stops the duration scan of songs that never end or
+// loop, which is about 6 hours of ticks at the default tempo
+const maxSimulatedTicks = 1000000
+
+// notReached marks the orders a timeline never plays
+const notReached = ^uint64(0)
+
+// timeline is when things happen in the song at the player's timing,
+// worked out by playing it through without mixing
+type timeline struct {
+	// length is the number of samples until the song ends or loops back
+	length uint64
+	// orderStarts holds how many samples into the song each order first
+	// plays, or notReached
+	orderStarts []uint64
+	// loopStart is how many samples into the song it carries on from when
+	// it loops back
+	loopStart uint64
+}
+
+// simulate plays the song from order start without mixing and returns the
+// number of samples until it ends or loops back. Orders it plays are marked
+// in visited when it isn't nil
+func (p *Player) simulate(start uint32, visited []bool) uint64 {
+	sim := p.newSimulation(start)
+	var length uint64
+	for ticks := 0; ticks < maxSimulatedTicks; ticks++ {
+		sim.tick()
+		if sim.State.SongHasEnded || sim.State.HasLooped {
+			break
+		}
+		if visited != nil {
+			visited[sim.State.playingPosition] = true
+		}
+		length += uint64(sim.State.SamplesPerVBlank)
+	}
+	return length
+}
+
+// newSimulation returns a player with the same timing that plays the song
+// from order start without mixing, to work out where and when rows play
+func (p *Player) newSimulation(start uint32) *Player {
+	sim := &Player{
+		SampleRate:    p.SampleRate,
+		VBlankTiming:  p.VBlankTiming,
+		Compatibility: p.Compatibility,
+		TempoScale:    p.TempoScale,
+		Song:          p.Song,
+		SongLoaded:    true,
+		SongPlaying:   true,
+	}
+	sim.State = newPlayerState(p.Song)
+	sim.setStandard(p.Standard)
+	sim.State.SongPatternPosition = start
+	sim.State.startFirstRow()
+	return sim
+}
+
+// orderTime returns how many samples into the song position first plays.
+// ok is false if the song ends or loops without reaching it
+func (p *Player) orderTime(position uint32) (samples uint64, ok bool) {
+	starts := p.State.timeline.orderStarts
+	if int(position) >= len(starts) || starts[position] == notReached {
+		return 0, false
+	}
+	return starts[position], true
+}
+
+// scanTimeline plays sim, a simulation from the start of the song, through
+// to its end. It only reads the song, so it runs without the player's lock
+func scanTimeline(sim *Player) timeline {
+	t := timeline{orderStarts: make([]uint64, len(sim.Song.Positions))}
+	for position := range t.orderStarts {
+		t.orderStarts[position] = notReached
+	}
+	for ticks := 0; ticks < maxSimulatedTicks; ticks++ {
+		sim.tick()
+		if sim.State.SongHasEnded || sim.State.HasLooped {
+			break
+		}
+		if position := sim.State.playingPosition; t.orderStarts[position] == notReached {
+			t.orderStarts[position] = t.length
+		}
+		t.length += uint64(sim.State.SamplesPerVBlank)
+	}
+
+	// The simulation doesn't loop, so it stops on the order a jump back
+	// goes to, or past the end of the order list
+	target := sim.State.SongPatternPosition
+	if target >= sim.Song.NumUsedPatterns {
+		target = sim.Song.restartPosition()
+	}
+	if int(target) < len(t.orderStarts) && t.orderStarts[target] != notReached {
+		t.loopStart = t.orderStarts[target]
+	}
+	return t
+}
+
+// updateTimeline works out the timeline at the player's current timing.
+// The song is played through with the player unlocked so the mixer isn't
+// held up, and the result is dropped if the song or timing changes
+// meanwhile
+func (p *Player) updateTimeline() {
+	p.mu.Lock()
+	if !p.SongLoaded {
+		p.mu.Unlock()
+		return
+	}
+	state, changes := p.State, p.timingChanges
+	sim := p.newSimulation(0)
+	p.mu.Unlock()
+
+	t := scanTimeline(sim)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State == state && p.timingChanges == changes {
+		state.timeline = t
+	}
+}
+
+// changeTiming makes a change to when rows play with the player locked,
+// then works out the timeline again
+func (p *Player) changeTiming(change func()) {
+	p.mu.Lock()
+	change()
+	p.timingChanges++
+	p.mu.Unlock()
+	p.updateTimeline()
+}
+
+// Length returns the number of samples the song plays before it ends or
+// first loops back
+func (p *Player) Length() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.SongLoaded {
+		return 0
+	}
+	return p.State.timeline.length
+}
+
+// Duration returns how long the song plays before it ends or first loops
+func (p *Player) Duration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.SongLoaded {
+		return 0
+	}
+	return time.Duration(p.State.timeline.length) * time.Second / time.Duration(p.SampleRate)
+}
+
+// Position returns the number of samples played since the song was loaded
+func (p *Player) Position() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.SongLoaded {
+		return 0
+	}
+	return p.State.samplePosition
+}
+
+// Elapsed returns how far into the song playback is, following seeks and
+// starting over when the song loops
+func (p *Player) Elapsed() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.SongLoaded {
+		return 0
+	}
+	return time.Duration(p.State.songPosition) * time.Second / time.Duration(p.SampleRate)
+}
+
+// Remaining returns the number of samples left before the song ends,
+// counting the loops LoopCount has still to play. ok is false when the
+// song loops forever
+func (p *Player) Remaining() (samples int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.SongLoaded || p.LoopCount == LoopForever {
+		return 0, false
+	}
+	t := p.State.timeline
+	var left uint64
+	if p.State.songPosition < t.length {
+		left = t.length - p.State.songPosition
+	}
+	if loops := p.LoopCount - p.State.loops; loops > 0 {
+		left += uint64(loops) * (t.length - t.loopStart)
+	}
+	return int(left), true
+}
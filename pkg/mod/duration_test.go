@@ -0,0 +1,132 @@
+package mod
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		orders []uint8
+		cells  []cell
+		length uint64
+		// starts holds when each order first plays, -1 if it never does
+		starts []int64
+	}{
+		{
+			"plain", []uint8{0, 1}, nil,
+			2 * testPatternSamples, []int64{0, testPatternSamples},
+		},
+		{
+			"pattern break", []uint8{0, 1},
+			[]cell{{row: 15, effect: 0xd}},
+			16*testRowSamples + testPatternSamples, []int64{0, 16 * testRowSamples},
+		},
+		{
+			"speed", []uint8{0, 1},
+			[]cell{{effect: 0xf, argument: 3}},
+			testPatternSamples, []int64{0, testPatternSamples / 2},
+		},
+		{
+			"jump back", []uint8{0, 1, 2},
+			[]cell{{pattern: 1, effect: 0xb}},
+			testPatternSamples + testRowSamples, []int64{0, testPatternSamples, -1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, buildSong(test.orders, 3, test.cells...))
+			if got := p.Length(); got != test.length {
+				t.Errorf("got length %d, want %d", got, test.length)
+			}
+			if remaining, ok := p.Remaining(); !ok || uint64(remaining) != test.length {
+				t.Errorf("got %d remaining, want %d", remaining, test.length)
+			}
+			for order, want := range test.starts {
+				got, ok := p.orderTime(uint32(order))
+				if want < 0 && ok {
+					t.Errorf("order %d plays at %d, want never", order, got)
+				} else if want >= 0 && (!ok || got != uint64(want)) {
+					t.Errorf("order %d plays at %d, want %d", order, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFollowsTiming(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(p *Player)
+		length   uint64
+		duration time.Duration
+	}{
+		{"unchanged", func(p *Player) {}, testPatternSamples, 7680 * time.Millisecond},
+		{"tempo scale", func(p *Player) { p.SetTempoScale(2) }, testPatternSamples / 2, 3840 * time.Millisecond},
+		{"sample rate", func(p *Player) { p.SetSampleRate(2 * testSampleRate) }, 2 * testPatternSamples, 7680 * time.Millisecond},
+		{
+			"vBlank timing", func(p *Player) { p.SetStandard(NTSC); p.SetVBlankTiming(true) },
+			64 * 6 * (testSampleRate / 60), 6397440 * time.Microsecond,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, buildSong([]uint8{0}, 1))
+			test.change(p)
+			if got := p.Length(); got != test.length {
+				t.Errorf("got length %d, want %d", got, test.length)
+			}
+			if got := p.Duration(); got != test.duration {
+				t.Errorf("got duration %v, want %v", got, test.duration)
+			}
+		})
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	jumpBack := buildSong([]uint8{0, 1}, 2, cell{pattern: 1, row: 32, effect: 0xb, argument: 1})
+	tests := []struct {
+		name      string
+		module    []byte
+		loopCount int
+		remaining int
+	}{
+		{"once", buildSong([]uint8{0}, 1), 0, testPatternSamples},
+		{"looped", buildSong([]uint8{0}, 1), 2, 3 * testPatternSamples},
+		{"jump back", jumpBack, 0, testPatternSamples + 33*testRowSamples},
+		{"looped jump back", jumpBack, 2, testPatternSamples + 3*33*testRowSamples},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, test.module)
+			p.SetLoopCount(test.loopCount)
+			if remaining, ok := p.Remaining(); !ok || remaining != test.remaining {
+				t.Fatalf("got %d remaining, want %d", remaining, test.remaining)
+			}
+			// Remaining counts down with each sample through the loops,
+			// and the song ends when it gets to 0
+			buf := make([][2]float32, 1000)
+			streamed := 0
+			for {
+				n, ok := p.Stream(buf)
+				streamed += n
+				if !ok {
+					break
+				}
+				if remaining, _ := p.Remaining(); remaining != test.remaining-streamed {
+					t.Fatalf("got %d remaining after %d samples, want %d", remaining, streamed, test.remaining-streamed)
+				}
+			}
+			if streamed != test.remaining {
+				t.Errorf("streamed %d samples, want %d", streamed, test.remaining)
+			}
+		})
+	}
+
+	p := loadSong(t, buildSong([]uint8{0}, 1))
+	p.SetLoopCount(LoopForever)
+	if remaining, ok := p.Remaining(); ok {
+		t.Errorf("got %d remaining of a song that loops forever, want unknown", remaining)
+	}
+}
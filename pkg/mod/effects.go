@@ -16,6 +16,9 @@ func fineTunePeriod(period uint32, fineTune uint32, useFineTuneTable bool) uint3
 	return uint32(float32(period) * scaleFineTune[int8(fineTune)])
 }
 
+// levelDecay is how much of a channel's level is left after each tick
+const levelDecay = 0.7
+
 func changeNote(currentPeriod uint32, change int32) uint32 {
 	result := int32(currentPeriod) + change
 	if result > 856 {
@@ -44,10 +47,10 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 		}
 	case 1:
 		// slide up
-		channel.noteChange = -int32(note.EffectArgument)
+		channel.noteChange = -p.slideSpeed(channel, note.EffectArgument)
 	case 2:
 		// slide down
-		channel.noteChange = int32(note.EffectArgument)
+		channel.noteChange = p.slideSpeed(channel, note.EffectArgument)
 	case 3:
 		// tone portamento
 		if note.Period != 0 {
@@ -67,9 +70,7 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 		}
 		channel.lastPortaSpeed = channel.noteChange
 		channel.lastPortaTarget = channel.periodTarget
-		if oldValues.SampleNum == channel.SampleNum {
-			channel.samplePos = oldValues.samplePos
-		}
+		p.keepPortamentoSample(channel, oldValues, false)
 
 	case 4:
 		//vibrato
@@ -96,7 +97,7 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 			channel.periodTarget = channel.lastPortaTarget
 		}
 		channel.period = oldValues.period
-		channel.samplePos = oldValues.samplePos
+		p.keepPortamentoSample(channel, oldValues, true)
 		channel.lastPortaTarget = channel.periodTarget
 		channel.noteChange = channel.lastPortaSpeed
 	case 6:
@@ -146,7 +147,7 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 	case 11:
 		//position jump
 		if uint32(note.EffectArgument) <= p.State.SongPatternPosition {
-			p.State.HasLooped = true
+			p.State.jumpingBack = true
 		}
 		p.State.NextPosition = int32(note.EffectArgument)
 	case 12:
@@ -181,7 +182,8 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 		case 6:
 			// patternloop
 			if extArgument == 0 {
-				p.State.PatternLoopPosition = &p.State.CurrentLine
+				line := p.State.CurrentLine
+				p.State.PatternLoopPosition = &line
 			} else {
 				if p.State.PatternLoop == 0 {
 					p.State.PatternLoop = int32(extArgument)
@@ -191,7 +193,10 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 
 				if p.State.PatternLoop > 0 && p.State.PatternLoopPosition != nil {
 					p.State.SetPatternPosition = true
-				} else {
+				} else if p.quirks().loopStartAfterLoop {
+					line := p.State.CurrentLine + 1
+					p.State.PatternLoopPosition = &line
+				} else if p.quirks().forgetLoopStart {
 					p.State.PatternLoopPosition = nil
 				}
 			}
@@ -232,11 +237,12 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 		}
 	case 15:
 		// Set Speed
-		if note.EffectArgument <= 31 {
+		if note.EffectArgument <= 31 || p.quirks().speedOnly {
 			p.State.SongSpeed = uint32(note.EffectArgument)
 		} else {
-			vBlanksPerSec := float32(note.EffectArgument) * 0.4
-			p.State.SamplesPerVBlank = uint32(float32(p.SampleRate) / vBlanksPerSec)
+			p.State.Tempo = uint32(note.EffectArgument)
+			p.State.tempoSet = true
+			p.updateTiming()
 		}
 	default:
 		fmt.Printf("Unhandled effect %x\n", note.Effect)
@@ -246,6 +252,7 @@ func (p *Player) generateEffect(note *Note, channelNum uint, oldValues *ChannelI
 func (p *Player) updateEffects() {
 	for idx := range p.State.Channels {
 		channel := p.State.Channels[idx]
+		channel.level *= levelDecay
 		if channel.SampleNum == 0 {
 			continue
 		}
@@ -254,6 +261,7 @@ func (p *Player) updateEffects() {
 			channel.cutNoteDelay--
 			if channel.cutNoteDelay == 0 {
 				channel.cutNoteDelay = 0
+				p.fadeOut(channel, channel)
 				channel.size = 0
 			}
 		}
@@ -261,6 +269,7 @@ func (p *Player) updateEffects() {
 		if channel.retriggerDelay > 0 {
 			channel.retriggerCounter++
 			if channel.retriggerDelay == channel.retriggerCounter {
+				p.fadeOut(channel, channel)
 				channel.samplePos = 0
 				channel.retriggerCounter = 0
 			}
@@ -0,0 +1,138 @@
+package mod
+
+// EventType says what an Event is about
+type EventType int
+
+const (
+	// TickEvent is sent on every tick, including the first tick of a row
+	TickEvent EventType = iota
+	// RowEvent is sent when a row is played, with the row's notes
+	RowEvent
+	// OrderChangeEvent is sent when playback moves to another position
+	OrderChangeEvent
+	// LoopEvent is sent when the song jumps back to an earlier position
+	LoopEvent
+	// SongEndEvent is sent once the song has played to its end
+	SongEndEvent
+)
+
+func (eventType EventType) String() string {
+	return [...]string{"Tick", "Row", "OrderChange", "Loop", "SongEnd"}[eventType]
+}
+
+// Event describes something that happened during playback
+type Event struct {
+	Type EventType
+	// SamplePosition is the output sample at which the event happened
+	SamplePosition uint64
+	Order          uint32
+	Pattern        uint8
+	Row            uint32
+	Tick           uint32
+	// Notes holds the notes of the row for RowEvent
+	Notes Row
+}
+
+// EventHandler receives playback events
+type EventHandler func(Event)
+
+type eventSubscription struct {
+	id      int
+	handler EventHandler
+}
+
+// AddEventHandler registers handler for playback events and returns an id
+// for RemoveEventHandler. Handlers run on the goroutine calling Stream or
+// NextSample, after the samples are mixed, and may call Player methods
+func (p *Player) AddEventHandler(handler EventHandler) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextHandlerID++
+	p.eventHandlers = append(p.eventHandlers, eventSubscription{id: p.nextHandlerID, handler: handler})
+	return p.nextHandlerID
+}
+
+// RemoveEventHandler unregisters the handler with the given id
+func (p *Player) RemoveEventHandler(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for idx, subscription := range p.eventHandlers {
+		if subscription.id == id {
+			p.eventHandlers = append(p.eventHandlers[:idx:idx], p.eventHandlers[idx+1:]...)
+			return
+		}
+	}
+}
+
+// emit queues an event of the given type at the current position, to be
+// handed out once the player is unlocked. The notes are copied, as the
+// pattern they come from is still being played
+func (p *Player) emit(eventType EventType, notes Row) {
+	if len(p.eventHandlers) == 0 {
+		return
+	}
+	ps := p.State
+	tick := uint32(0)
+	if ps.CurrentVBlank > 0 {
+		tick = ps.CurrentVBlank - 1
+	}
+	if notes != nil {
+		notes = append(Row(nil), notes...)
+	}
+	p.pendingEvents = append(p.pendingEvents, Event{
+		Type:           eventType,
+		SamplePosition: ps.samplePosition,
+		Order:          ps.playingPosition,
+		Pattern:        p.Song.Positions[ps.playingPosition],
+		Row:            ps.playingLine,
+		Tick:           tick,
+		Notes:          notes,
+	})
+}
+
+// pendingDispatch is the events queued while the player was locked, with
+// the handlers to hand them to
+type pendingDispatch struct {
+	events   []Event
+	handlers []eventSubscription
+}
+
+// takeEvents empties the event queue. It must be called with the player
+// locked, and the result dispatched once it is unlocked
+func (p *Player) takeEvents() pendingDispatch {
+	if len(p.pendingEvents) == 0 {
+		return pendingDispatch{}
+	}
+	events := pendingDispatch{p.pendingEvents, p.eventHandlers}
+	p.pendingEvents = nil
+	return events
+}
+
+// dispatch calls the handlers with the events
+func (d pendingDispatch) dispatch() {
+	for _, event := range d.events {
+		for _, subscription := range d.handlers {
+			subscription.handler(event)
+		}
+	}
+}
+
+// loopAgain decides whether the song carries on when it ends or jumps
+// back, counting the loop if it does
+func (p *Player) loopAgain() bool {
+	if p.LoopCount != LoopForever && p.State.loops >= p.LoopCount {
+		return false
+	}
+	p.State.loops++
+	p.State.HasLooped = true
+	p.emit(LoopEvent, nil)
+	return true
+}
+
+// endSong stops the song, telling handlers the first time
+func (p *Player) endSong() {
+	if !p.State.SongHasEnded {
+		p.State.SongHasEnded = true
+		p.emit(SongEndEvent, nil)
+	}
+}
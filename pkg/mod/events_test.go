@@ -0,0 +1,87 @@
+package mod
+
+import "testing"
+
+func TestEvents(t *testing.T) {
+	// Order 1 jumps back to order 0 on its second row, which plays the song
+	// through twice with the loop
+	p := loadSong(t, buildSong([]uint8{0, 1}, 2,
+		cell{sample: 1, period: 428},
+		cell{pattern: 1, row: 1, effect: 0xb},
+	))
+	p.SetLoopCount(1)
+	var events []Event
+	p.AddEventHandler(func(event Event) {
+		events = append(events, event)
+	})
+	for {
+		if _, ok := p.Stream(make([][2]float32, 4096)); !ok {
+			break
+		}
+	}
+
+	type mark struct {
+		eventType      EventType
+		order          uint32
+		samplePosition uint64
+	}
+	want := []mark{
+		{OrderChangeEvent, 0, 0},
+		{OrderChangeEvent, 1, testPatternSamples},
+		{LoopEvent, 1, testPatternSamples + 2*testRowSamples},
+		{OrderChangeEvent, 0, testPatternSamples + 2*testRowSamples},
+		{OrderChangeEvent, 1, 2*testPatternSamples + 2*testRowSamples},
+		{SongEndEvent, 1, 2*testPatternSamples + 4*testRowSamples},
+	}
+	var got []mark
+	rows, ticks := 0, 0
+	for _, event := range events {
+		switch event.Type {
+		case RowEvent:
+			if event.Tick != 0 || event.SamplePosition != uint64(rows*testRowSamples) {
+				t.Errorf("row %d played on tick %d at %d", rows, event.Tick, event.SamplePosition)
+			}
+			rows++
+		case TickEvent:
+			ticks++
+		default:
+			got = append(got, mark{event.Type, event.Order, event.SamplePosition})
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("event %d is %v, want %v", idx, got[idx], want[idx])
+		}
+	}
+	if rows != 2*(64+2) || ticks < 6*rows {
+		t.Errorf("got %d rows and %d ticks", rows, ticks)
+	}
+}
+
+func TestEventNotesAreCopied(t *testing.T) {
+	p := loadSong(t, buildSong([]uint8{0}, 1, cell{sample: 1, period: 428}))
+	var first Event
+	id := p.AddEventHandler(func(event Event) {
+		if event.Type == RowEvent && first.Notes == nil {
+			first = event
+		}
+	})
+	p.Stream(make([][2]float32, 1))
+	p.RemoveEventHandler(id)
+	if first.Notes[0].Period != 428 {
+		t.Fatalf("got notes %+v", first.Notes)
+	}
+
+	// The event's notes and the song's are apart
+	first.Notes[0].Period = 214
+	if period := p.Song.Patterns[0].Rows[0][0].Period; period != 428 {
+		t.Errorf("changing the event's notes changed the song's to %d", period)
+	}
+	p.Song.Patterns[0].Rows[0][0].Period = 113
+	if first.Notes[0].Period != 214 {
+		t.Errorf("changing the song changed the event's notes to %d", first.Notes[0].Period)
+	}
+}
@@ -0,0 +1,55 @@
+package mod
+
+import "math"
+
+const (
+	limiterCeiling = -1  // dBFS the limiter never lets the output exceed
+	limiterKnee    = 6   // dB wide soft knee centered below the ceiling
+	limiterRelease = 0.1 // seconds for the gain to recover
+)
+
+// limiter is a soft knee peak limiter with instant attack, used to keep busy
+// modules out of the hard clipping in the output stage
+type limiter struct {
+	sampleRate uint32
+	release    float32
+	gain       float32
+}
+
+// limiterGain returns the gain needed to bring a peak of level dB under the
+// soft knee curve
+func limiterGain(level float64) float64 {
+	kneeStart := float64(limiterCeiling - limiterKnee/2)
+	var out float64
+	switch {
+	case level <= kneeStart:
+		return 1
+	case level < limiterCeiling+limiterKnee/2:
+		over := level - kneeStart
+		out = level - over*over/(2*limiterKnee)
+	default:
+		out = limiterCeiling
+	}
+	return math.Pow(10, (out-level)/20)
+}
+
+func (l *limiter) process(left float32, right float32, sampleRate uint32) (float32, float32) {
+	if l.sampleRate != sampleRate {
+		l.sampleRate = sampleRate
+		l.release = float32(1 - math.Exp(-1/(limiterRelease*float64(sampleRate))))
+		l.gain = 1
+	}
+
+	peak := math.Max(math.Abs(float64(left)), math.Abs(float64(right)))
+	target := float32(1)
+	if peak > 0 {
+		target = float32(limiterGain(20 * math.Log10(peak)))
+	}
+
+	if target < l.gain {
+		l.gain = target
+	} else {
+		l.gain += (target - l.gain) * l.release
+	}
+	return left * l.gain, right * l.gain
+}
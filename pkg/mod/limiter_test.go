@@ -0,0 +1,60 @@
+package mod
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLimiterGain(t *testing.T) {
+	ceiling := math.Pow(10, limiterCeiling/20.0)
+	tests := []struct {
+		level float64
+		// want is the level out of the limiter, in dB
+		want float64
+	}{
+		{-20, -20},
+		{limiterCeiling - limiterKnee/2, limiterCeiling - limiterKnee/2},
+		// Halfway into the knee the level is pulled down by an eighth of
+		// the knee's width
+		{limiterCeiling, limiterCeiling - limiterKnee/8.0},
+		{limiterCeiling + limiterKnee/2, limiterCeiling},
+		{12, limiterCeiling},
+	}
+	for _, test := range tests {
+		out := test.level + 20*math.Log10(limiterGain(test.level))
+		if math.Abs(out-test.want) > 1e-9 {
+			t.Errorf("%v dB comes out at %v dB, want %v", test.level, out, test.want)
+		}
+	}
+	if gain := limiterGain(20 * math.Log10(2)); math.Abs(2*gain-ceiling) > 1e-9 {
+		t.Errorf("a peak at 2 comes out at %v, want %v", 2*gain, ceiling)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ceiling := float32(math.Pow(10, limiterCeiling/20.0))
+	var l limiter
+	// The attack is instant, so no sample of a loud burst gets through
+	for i := 0; i < 1000; i++ {
+		value := float32(2)
+		if i%2 == 1 {
+			value = -4
+		}
+		left, right := l.process(value, -value/2, testSampleRate)
+		if left > ceiling+1e-6 || left < -ceiling-1e-6 || right > ceiling+1e-6 || right < -ceiling-1e-6 {
+			t.Fatalf("sample %d came out at %v and %v, over the ceiling %v", i, left, right, ceiling)
+		}
+	}
+
+	// Quiet output afterwards is only turned down until the gain recovers
+	left, _ := l.process(0.1, 0.1, testSampleRate)
+	if left >= 0.05 {
+		t.Errorf("quiet sample right after the burst came out at %v", left)
+	}
+	for i := 0; i < testSampleRate; i++ {
+		left, _ = l.process(0.1, 0.1, testSampleRate)
+	}
+	if !near(left, 0.1) {
+		t.Errorf("quiet sample a second later came out at %v, want 0.1", left)
+	}
+}
@@ -2,9 +2,43 @@ package mod
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
 )
 
+// defaultTempo is the CIA tempo in BPM that gives 50 ticks per second
+const defaultTempo = 125
+
+var ntscHint = regexp.MustCompile(`(?i)\b(ntsc|60 ?hz)\b`)
+var palHint = regexp.MustCompile(`(?i)\b(pal|50 ?hz)\b`)
+
+// StandardHint looks for a mention of NTSC or PAL in the song and sample
+// names, which is the only place a mod records what it was written for
+func (s *Song) StandardHint() (Standard, bool) {
+	names := []string{s.Name}
+	for _, sample := range s.Samples {
+		names = append(names, sample.Name)
+	}
+	for _, name := range names {
+		if ntscHint.MatchString(name) {
+			return NTSC, true
+		}
+		if palHint.MatchString(name) {
+			return PAL, true
+		}
+	}
+	return "", false
+}
+
+// autoStandard returns the Standard the song hints at, or PAL like most
+// modules were made on when it doesn't hint at one
+func (s *Song) autoStandard() Standard {
+	if standard, ok := s.StandardHint(); ok {
+		return standard
+	}
+	return PAL
+}
+
 func isStandardNotePeriod(period uint32) bool {
 	if period == 0 {
 		return true
@@ -130,3 +164,12 @@ func newPattern(data []byte) *Pattern {
 	}
 	return &p
 }
+
+// restartPosition returns the order the song goes back to when it loops
+// at its end. Most trackers store 127 there, meaning the first order
+func (s *Song) restartPosition() uint32 {
+	if s.endPosition < s.NumUsedPatterns {
+		return s.endPosition
+	}
+	return 0
+}
@@ -0,0 +1,167 @@
+package mod
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Metadata describes a song for cataloguing. Durations are in seconds
+type Metadata struct {
+	Title    string           `json:"title"`
+	Format   string           `json:"format"`
+	Channels int              `json:"channels"`
+	Samples  []SampleMetadata `json:"samples"`
+	Orders   []int            `json:"orders"`
+	Patterns int              `json:"patterns"`
+	Effects  []string         `json:"effects"`
+	Duration float64          `json:"duration"`
+	Subsongs []Subsong        `json:"subsongs"`
+}
+
+// SampleMetadata describes one sample slot of a song. Sizes are in bytes
+type SampleMetadata struct {
+	Number     int    `json:"number"`
+	Name       string `json:"name"`
+	Size       uint32 `json:"size"`
+	LoopStart  uint32 `json:"loopStart"`
+	LoopLength uint32 `json:"loopLength"`
+	FineTune   int8   `json:"fineTune"`
+	Volume     uint8  `json:"volume"`
+}
+
+// Subsong is a part of the order list that plays on its own, starting at
+// an order the main song never reaches
+type Subsong struct {
+	Order    uint32  `json:"order"`
+	Duration float64 `json:"duration"`
+}
+
+// metadataSampleRate is the rate durations are worked out at when there is
+// no player to take it from
+const metadataSampleRate = 48000
+
+// Metadata describes the song, timing it with the default player settings
+// and the Standard the song hints at
+func (s *Song) Metadata() Metadata {
+	p := NewModPlayer(metadataSampleRate)
+	p.Song = s
+	p.SongLoaded = true
+	p.State = newPlayerState(s)
+	if standard, ok := s.StandardHint(); ok {
+		p.setStandard(standard)
+	}
+	p.State.timeline = scanTimeline(p.newSimulation(0))
+	return p.metadata()
+}
+
+// Metadata describes the loaded song, timing it with the player's settings.
+// The subsongs are timed on a copy of the player, so that playback carries
+// on while they are
+func (p *Player) Metadata() Metadata {
+	p.mu.Lock()
+	if !p.SongLoaded {
+		p.mu.Unlock()
+		return Metadata{}
+	}
+	c := p.newSimulation(0)
+	c.State.timeline = p.State.timeline
+	p.mu.Unlock()
+
+	return c.metadata()
+}
+
+func (p *Player) metadata() Metadata {
+	s := p.Song
+	m := Metadata{
+		Title:    trimName(s.Name),
+		Format:   s.Format.Tag,
+		Channels: int(s.NumChannels),
+		Samples:  make([]SampleMetadata, len(s.Samples)),
+		Orders:   make([]int, s.NumUsedPatterns),
+		Patterns: len(s.Patterns),
+		Effects:  s.usedEffects(),
+		Subsongs: []Subsong{},
+	}
+	for order, pattern := range s.Positions[:s.NumUsedPatterns] {
+		m.Orders[order] = int(pattern)
+	}
+	for idx, sample := range s.Samples {
+		m.Samples[idx] = SampleMetadata{
+			Number:     idx + 1,
+			Name:       trimName(sample.Name),
+			Size:       sample.size,
+			LoopStart:  sample.repeatOffset,
+			LoopLength: sample.repeatLength,
+			FineTune:   sample.FineTune(),
+			Volume:     sample.volume,
+		}
+	}
+
+	// Orders the song plays from the start aren't subsongs
+	visited := make([]bool, s.NumUsedPatterns)
+	for order := range visited {
+		visited[order] = p.State.timeline.orderStarts[order] != notReached
+	}
+	m.Duration = p.seconds(p.State.timeline.length)
+	for order := range visited {
+		if visited[order] {
+			continue
+		}
+		length := p.simulate(uint32(order), visited)
+		if length > 0 {
+			m.Subsongs = append(m.Subsongs, Subsong{
+				Order:    uint32(order),
+				Duration: p.seconds(length),
+			})
+		}
+	}
+	return m
+}
+
+func (p *Player) seconds(samples uint64) float64 {
+	return float64(samples) / float64(p.SampleRate)
+}
+
+// usedEffects lists the effects used in the patterns of the order list,
+// written the way trackers show them: 0-F, with E commands as E0-EF.
+// Arpeggio with no argument is an empty effect and is left out
+func (s *Song) usedEffects() []string {
+	seen := make(map[string]bool)
+	for _, patternIdx := range s.Positions[:s.NumUsedPatterns] {
+		if int(patternIdx) >= len(s.Patterns) {
+			continue
+		}
+		for _, row := range s.Patterns[patternIdx].Rows {
+			for _, note := range row {
+				if note.Effect == 0 && note.EffectArgument == 0 {
+					continue
+				}
+				name := fmt.Sprintf("%X", note.Effect)
+				if note.Effect == 14 {
+					name += fmt.Sprintf("%X", note.EffectArgument>>4)
+				}
+				seen[name] = true
+			}
+		}
+	}
+	effects := make([]string, 0, len(seen))
+	for name := range seen {
+		effects = append(effects, name)
+	}
+	sort.Strings(effects)
+	return effects
+}
+
+// trimName cuts a name at its zero padding and decodes it from the Amiga's
+// Latin-1
+func trimName(name string) string {
+	if end := strings.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+	runes := make([]rune, len(name))
+	for idx := 0; idx < len(name); idx++ {
+		runes[idx] = rune(name[idx])
+	}
+	return strings.TrimSpace(string(runes))
+}
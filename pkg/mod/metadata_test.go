@@ -0,0 +1,61 @@
+package mod
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataSubsongs(t *testing.T) {
+	tests := []struct {
+		name     string
+		orders   []uint8
+		cells    []cell
+		duration float64
+		subsongs []Subsong
+	}{
+		{"plain", []uint8{0, 1}, nil, 15.36, []Subsong{}},
+		{
+			"jump back", []uint8{0, 1, 2},
+			[]cell{{pattern: 1, effect: 0xb}},
+			7.8, []Subsong{{Order: 2, Duration: 7.68}},
+		},
+		{
+			"jump forward", []uint8{0, 1, 2},
+			[]cell{{row: 31, effect: 0xb, argument: 2}},
+			11.52, []Subsong{{Order: 1, Duration: 15.36}},
+		},
+		{
+			"two subsongs", []uint8{0, 1, 2, 1},
+			[]cell{{effect: 0xb}, {pattern: 1, row: 31, effect: 0xb, argument: 1}},
+			0.12, []Subsong{{Order: 1, Duration: 3.84}, {Order: 2, Duration: 11.52}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module := buildSong(test.orders, 3, test.cells...)
+			p := loadSong(t, module)
+			// The song times the same without a player
+			for _, m := range []Metadata{p.Metadata(), p.Song.Metadata()} {
+				if m.Duration != test.duration {
+					t.Errorf("got duration %v, want %v", m.Duration, test.duration)
+				}
+				if !reflect.DeepEqual(m.Subsongs, test.subsongs) {
+					t.Errorf("got subsongs %+v, want %+v", m.Subsongs, test.subsongs)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataEffects(t *testing.T) {
+	module := buildSong([]uint8{0}, 2,
+		cell{effect: 0xc, argument: 32},
+		cell{row: 1, effect: 0xe, argument: 0x61},
+		cell{row: 2, effect: 0x0},
+		cell{row: 3, effect: 0x0, argument: 0x37},
+		cell{pattern: 1, effect: 0xf, argument: 3})
+	want := []string{"0", "C", "E6"}
+	if got := loadSong(t, module).Metadata().Effects; !reflect.DeepEqual(got, want) {
+		t.Errorf("got effects %q, want %q", got, want)
+	}
+}
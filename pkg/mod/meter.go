@@ -0,0 +1,84 @@
+package mod
+
+import "math"
+
+// meterBlockSize is the number of output samples levels are measured over
+const meterBlockSize = 1024
+
+// ScopeSize is the number of recent output samples kept for each channel's
+// oscilloscope
+const ScopeSize = 512
+
+// Meter is the level of a signal over the last complete block of output,
+// both between 0 and 1 for a signal that doesn't clip
+type Meter struct {
+	Peak float32
+	RMS  float32
+}
+
+// levelMeter measures a signal block by block
+type levelMeter struct {
+	peak       float32
+	sumSquares float32
+	last       Meter
+}
+
+func (m *levelMeter) add(value float32) {
+	if value < 0 {
+		value = -value
+	}
+	if value > m.peak {
+		m.peak = value
+	}
+	m.sumSquares += value * value
+}
+
+// finish ends a block of n samples and starts the next one
+func (m *levelMeter) finish(n int) {
+	m.last = Meter{
+		Peak: m.peak,
+		RMS:  float32(math.Sqrt(float64(m.sumSquares / float32(n)))),
+	}
+	m.peak = 0
+	m.sumSquares = 0
+}
+
+// measure adds the output sample and each channel's part in it to the
+// meters and scopes, finishing the block when it is full
+func (ps *PlayerState) measure(preLeft, preRight, left, right float32) {
+	ps.preLimiterLeftMeter.add(preLeft)
+	ps.preLimiterRightMeter.add(preRight)
+	ps.leftMeter.add(left)
+	ps.rightMeter.add(right)
+	for _, channel := range ps.Channels {
+		channel.meter.add(channel.output)
+		if channel.scope == nil {
+			channel.scope = make([]float32, ScopeSize)
+		}
+		channel.scope[ps.scopePos] = channel.output
+	}
+	ps.scopePos = (ps.scopePos + 1) % ScopeSize
+
+	ps.meterCount++
+	if ps.meterCount < meterBlockSize {
+		return
+	}
+	ps.preLimiterLeftMeter.finish(ps.meterCount)
+	ps.preLimiterRightMeter.finish(ps.meterCount)
+	ps.leftMeter.finish(ps.meterCount)
+	ps.rightMeter.finish(ps.meterCount)
+	for _, channel := range ps.Channels {
+		channel.meter.finish(ps.meterCount)
+	}
+	ps.meterCount = 0
+}
+
+// scopeSnapshot copies a channel's scope with the oldest sample first
+func (ps *PlayerState) scopeSnapshot(channel *ChannelInfo) []float32 {
+	scope := make([]float32, ScopeSize)
+	if channel.scope != nil {
+		n := copy(scope, channel.scope[ps.scopePos:])
+		copy(scope[n:], channel.scope[:ps.scopePos])
+	}
+	return scope
+}
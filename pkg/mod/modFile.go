@@ -1,5 +1,7 @@
 package mod
 
+import "math"
+
 func (p *Player) getSongRow() *Row {
 	patternIdx := p.Song.Positions[p.State.SongPatternPosition]
 	pattern := p.Song.Patterns[patternIdx]
@@ -14,9 +16,28 @@ func (p *Player) playNote(note Note, channelNum uint) {
 	if note.SampleNumber > 0 {
 		currentSample := p.Song.Samples[note.SampleNumber-1]
 		channel.volume = float32(currentSample.volume)
-		channel.size = currentSample.size
-		channel.SampleNum = note.SampleNumber
-		channel.fineTune = uint32(currentSample.fineTune)
+
+		swap := p.quirks().sampleSwap
+		switch {
+		case note.Period != 0 || channel.SampleNum == 0 || swap == swapImmediately:
+			channel.size = currentSample.size
+			channel.SampleNum = note.SampleNumber
+			channel.fineTune = uint32(currentSample.fineTune)
+			channel.pendingSampleNum = 0
+		case swap == swapAtLoopEnd:
+			// Giving the playing sample again drops a queued swap
+			channel.pendingSampleNum = 0
+			if note.SampleNumber != channel.SampleNum {
+				channel.pendingSampleNum = note.SampleNumber
+			}
+		}
+	}
+	// A queued sample plays from the next note, like ProTracker 2
+	if note.Period != 0 && channel.pendingSampleNum > 0 {
+		channel.SampleNum = channel.pendingSampleNum
+		channel.fineTune = uint32(p.Song.Samples[channel.SampleNum-1].fineTune)
+		channel.size = p.Song.Samples[channel.SampleNum-1].size
+		channel.pendingSampleNum = 0
 	}
 
 	channel.volumeChange = 0
@@ -39,7 +60,16 @@ func (p *Player) playNote(note Note, channelNum uint) {
 			channel.size = currentSample.size
 		}
 	}
+	channel.effect = note.Effect
+	channel.effectArgument = note.EffectArgument
+	if note.NoteName != "" {
+		channel.noteName = note.NoteName
+	}
 	p.generateEffect(&note, channelNum, &prevState)
+
+	if note.Period != 0 && (channel.samplePos != prevState.samplePos || channel.SampleNum != prevState.SampleNum) {
+		p.fadeOut(channel, &prevState)
+	}
 }
 
 func (p *Player) playLine() {
@@ -53,13 +83,37 @@ func (p *Player) playLine() {
 		p.State.NextPosition = -1
 	}
 
+	if p.State.jumpingBack {
+		p.State.jumpingBack = false
+		if !p.loopAgain() {
+			p.endSong()
+			return
+		}
+		p.State.songPosition, _ = p.orderTime(p.State.SongPatternPosition)
+	}
 	if p.State.SongPatternPosition >= p.Song.NumUsedPatterns {
-		if p.Song.endPosition < p.Song.NumUsedPatterns {
-			p.State.SongPatternPosition = p.Song.endPosition
-			p.State.HasLooped = true
-		} else {
-			p.State.SongHasEnded = true
+		if !p.loopAgain() {
+			p.endSong()
+			return
 		}
+		// Playing the song again starts from its initial speed and tempo,
+		// songs often slow down to end
+		p.State.SongPatternPosition = p.Song.restartPosition()
+		p.State.CurrentLine = 0
+		p.State.SongSpeed = 6
+		p.State.Tempo = defaultTempo
+		p.State.tempoSet = false
+		p.updateTiming()
+		p.State.songPosition, _ = p.orderTime(p.State.SongPatternPosition)
+	}
+
+	orderChanged := !p.State.started || p.State.playingPosition != p.State.SongPatternPosition
+	p.State.started = true
+	p.State.playingPosition = p.State.SongPatternPosition
+	p.State.playingLine = p.State.CurrentLine
+	p.State.recordRow(p.Song.Positions[p.State.playingPosition])
+	if orderChanged {
+		p.emit(OrderChangeEvent, nil)
 	}
 
 	row := *p.getSongRow()
@@ -67,6 +121,7 @@ func (p *Player) playLine() {
 		note := row[channelNum]
 		p.playNote(note, uint(channelNum))
 	}
+	p.emit(RowEvent, row)
 
 	if p.State.SetPatternPosition && p.State.PatternLoopPosition != nil {
 		p.State.SetPatternPosition = false
@@ -75,87 +130,242 @@ func (p *Player) playLine() {
 		p.State.CurrentLine++
 		if p.State.CurrentLine >= 64 {
 			p.State.SongPatternPosition++
-			if p.State.SongPatternPosition >= p.Song.NumUsedPatterns {
-				p.State.SongHasEnded = true
-			}
 			p.State.CurrentLine = 0
 		}
 	}
 }
 
+// tick runs the effects for one vBlank and plays the next row when due
+func (p *Player) tick() {
+	p.State.CurrentVBlankSample = 0
+
+	p.updateEffects()
+
+	if p.State.CurrentVBlank >= p.State.SongSpeed {
+		if p.State.DelayLine > 0 {
+			p.State.DelayLine--
+		} else {
+			p.State.CurrentVBlank = 0
+			p.playLine()
+
+		}
+	}
+	p.State.CurrentVBlank++
+	p.emit(TickEvent, nil)
+}
+
+// NextSample mixes and returns the next output sample
 func (p *Player) NextSample() (left float32, right float32) {
+	p.mu.Lock()
+	left, right = p.nextSample()
+	events := p.takeEvents()
+	p.mu.Unlock()
+
+	events.dispatch()
+	return left, right
+}
+
+func (p *Player) nextSample() (left float32, right float32) {
 	if !(p.SongLoaded && p.SongPlaying) {
 		return
 	}
 
 	if p.State.CurrentVBlankSample >= p.State.SamplesPerVBlank {
-		p.State.CurrentVBlankSample = 0
-
-		p.updateEffects()
-
-		if p.State.CurrentVBlank >= p.State.SongSpeed {
-			if p.State.DelayLine > 0 {
-				p.State.DelayLine--
-			} else {
-				p.State.CurrentVBlank = 0
-				p.playLine()
-
-			}
+		p.tick()
+		if p.State.SongHasEnded {
+			return
 		}
-		p.State.CurrentVBlank++
 	}
 	p.State.CurrentVBlankSample++
+	p.State.samplePosition++
+	p.State.songPosition++
 
 	for channelNum := range p.State.Channels {
 		channel := p.State.Channels[channelNum]
+		channel.output = 0
+
+		if channel.fade.remaining > 0 {
+			fade := &channel.fade
+			if value, ok := nextSampleValue(fade.sample, &fade.samplePos, &fade.size, p.sampleStep(fade.period), p.Interpolation); ok {
+				scale := float32(fade.remaining) / float32(p.VolumeRamp+1)
+				left += value * fade.leftGain * scale
+				right += value * fade.rightGain * scale
+				channel.output += value * (fade.leftGain + fade.rightGain) / 2 * scale
+				fade.remaining--
+			} else {
+				fade.remaining = 0
+			}
+		}
+
 		if channel.size > 2 {
+			p.swapAtLoopEnd(channel)
 			currentSample := p.Song.Samples[channel.SampleNum-1]
-			if channel.size <= 2 {
+			value, ok := nextSampleValue(currentSample, &channel.samplePos, &channel.size, p.sampleStep(channel.period), p.Interpolation)
+			if !ok {
 				continue
 			}
 
-			if channel.samplePos >= float32(channel.size) {
-				overflow := channel.samplePos - float32(channel.size)
-				channel.samplePos = float32(currentSample.repeatOffset) + overflow
-				channel.size = currentSample.repeatOffset + currentSample.repeatLength
-				if channel.size <= 2 {
-					continue
-				}
+			targetLeft, targetRight := float32(0), float32(0)
+			if p.State.audible(channelNum) {
+				targetLeft, targetRight = p.channelGains(channelNum, channel.volume/64)
 			}
+			channel.leftGain = p.rampGain(channel.leftGain, targetLeft)
+			channel.rightGain = p.rampGain(channel.rightGain, targetRight)
 
-			rawValue := currentSample.data[uint32(channel.samplePos)]
-			channelValue := float32(rawValue) / 128 * channel.volume / 64
+			left += value * channel.leftGain
+			right += value * channel.rightGain
 
-			if channel.period != 0 {
-				channel.samplePos += (p.clockTicksPerDeviceSample / float32(channel.period))
+			level := value * (channel.leftGain + channel.rightGain) / 2
+			channel.output += level
+			if level < 0 {
+				level = -level
 			}
-
-			if channel.Muted {
-				continue
+			if level > channel.level {
+				channel.level = level
 			}
+		}
+	}
+	if p.Crossfeed {
+		left, right = p.State.crossfeed.process(left, right, p.SampleRate)
+	}
 
-			outputChannel := channelNum % 4
-			if outputChannel == 0 || outputChannel == 3 {
-				left += channelValue
-				switch p.MixingMode {
-				case StereoMixingMode:
-					right += channelValue * 0.33
-				case MonoMixingMode:
-					right += channelValue
-				}
+	gain := p.MasterVolume
+	if p.AutoHeadroom {
+		gain *= headroom(len(p.State.Channels))
+	}
+	left *= gain
+	right *= gain
 
-			} else {
-				right += channelValue
-				switch p.MixingMode {
-				case StereoMixingMode:
-					left += channelValue * 0.33
-				case MonoMixingMode:
-					left += channelValue
-				}
-			}
-		}
+	p.State.preLimiterLeft = left
+	p.State.preLimiterRight = right
+	if p.Limiter {
+		left, right = p.State.limiter.process(left, right, p.SampleRate)
 	}
 	p.State.leftChannel = left
 	p.State.rightChannel = right
+	p.State.measure(p.State.preLimiterLeft, p.State.preLimiterRight, left, right)
 	return
 }
+
+// swapAtLoopEnd switches a channel to its queued sample once the playing
+// one gets to the end of its loop, or its end if it has none. The channel
+// then carries on in the loop of the new sample, like Paula does
+func (p *Player) swapAtLoopEnd(channel *ChannelInfo) {
+	if channel.pendingSampleNum == 0 || channel.samplePos < float32(channel.size) {
+		return
+	}
+	channel.SampleNum = channel.pendingSampleNum
+	channel.fineTune = uint32(p.Song.Samples[channel.SampleNum-1].fineTune)
+	channel.pendingSampleNum = 0
+}
+
+// nextSampleValue reads the sample at pos and advances it by step, wrapping
+// into the loop when the end is reached. ok is false once the sample is done
+func nextSampleValue(sample *Sample, pos *float32, size *uint32, step float32, interpolation Interpolation) (value float32, ok bool) {
+	if *pos >= float32(*size) {
+		overflow := *pos - float32(*size)
+		*pos = float32(sample.repeatOffset) + overflow
+		*size = sample.repeatOffset + sample.repeatLength
+		if *size <= 2 {
+			return 0, false
+		}
+	}
+	idx := uint32(*pos)
+	if idx >= uint32(len(sample.data)) {
+		return 0, false
+	}
+	value = float32(sample.data[idx]) / 128
+	if interpolation == LinearInterpolation {
+		value += (sampleAfter(sample, idx, *size) - value) * (*pos - float32(idx))
+	}
+	*pos += step
+	return value, true
+}
+
+// sampleAfter returns the sample point following idx, which is the loop
+// start at the end of a looped sample and silence after a one-shot
+func sampleAfter(sample *Sample, idx uint32, size uint32) float32 {
+	next := idx + 1
+	if next >= size {
+		if sample.repeatLength <= 2 {
+			return 0
+		}
+		next = sample.repeatOffset
+	}
+	if next >= uint32(len(sample.data)) {
+		return 0
+	}
+	return float32(sample.data[next]) / 128
+}
+
+// sampleStep returns how far a sample advances per output sample at period
+func (p *Player) sampleStep(period uint32) float32 {
+	if period == 0 {
+		return 0
+	}
+	return p.clockTicksPerDeviceSample / float32(period)
+}
+
+// channelGains maps a channel volume to left and right output gains. The
+// channel is split into mid and side, the side scaled by the separation,
+// and its own side gets their sum and the opposite side their difference.
+// Past 100% the difference would go negative and turn the far side's phase
+// over, so the gains stop at the channel panned hard to its own side
+func (p *Player) channelGains(channelNum int, volume float32) (left float32, right float32) {
+	width := float32(p.stereoSeparation()) / 100
+	if width > 1 {
+		width = 1
+	}
+	mid := volume * (2 - width) / 2
+	side := volume * width / 2
+	near, far := mid+side, mid-side
+
+	outputChannel := channelNum % 4
+	if outputChannel == 0 || outputChannel == 3 {
+		return near, far
+	}
+	return far, near
+}
+
+// headroom returns the gain that keeps modules with many channels at
+// roughly the loudness of a 4 channel module
+func headroom(numChannels int) float32 {
+	if numChannels <= 4 {
+		return 1
+	}
+	return float32(math.Sqrt(4 / float64(numChannels)))
+}
+
+// rampGain moves current towards target by at most one ramp step
+func (p *Player) rampGain(current float32, target float32) float32 {
+	if p.VolumeRamp == 0 {
+		return target
+	}
+	step := 1 / float32(p.VolumeRamp)
+	if target > current+step {
+		return current + step
+	}
+	if target < current-step {
+		return current - step
+	}
+	return target
+}
+
+// fadeOut hands the voice in prev over to the channel's fade voice so it can
+// be faded out while the channel starts its new note
+func (p *Player) fadeOut(channel *ChannelInfo, prev *ChannelInfo) {
+	if p.VolumeRamp == 0 || prev.SampleNum == 0 || prev.size <= 2 {
+		return
+	}
+	channel.fade = fadeVoice{
+		sample:    p.Song.Samples[prev.SampleNum-1],
+		samplePos: prev.samplePos,
+		size:      prev.size,
+		period:    prev.period,
+		leftGain:  prev.leftGain,
+		rightGain: prev.rightGain,
+		remaining: p.VolumeRamp,
+	}
+	channel.leftGain = 0
+	channel.rightGain = 0
+}
@@ -0,0 +1,123 @@
+package mod
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChannelGains(t *testing.T) {
+	tests := []struct {
+		separation  uint32
+		left, right float32
+		pan         float32
+	}{
+		{0, 1, 1, 0},
+		{67, 1, 0.33, -0.67},
+		{100, 1, 0, -1},
+		// The far side doesn't go negative past 100%
+		{150, 1, 0, -1},
+		{200, 1, 0, -1},
+	}
+	for _, test := range tests {
+		p := NewModPlayer(testSampleRate)
+		p.SetStereoSeparation(test.separation)
+		// Channel 0 sits on the left, channel 1 on the right
+		left, right := p.channelGains(0, 1)
+		if !near(left, test.left) || !near(right, test.right) {
+			t.Errorf("%d%%: got gains %v and %v, want %v and %v", test.separation, left, right, test.left, test.right)
+		}
+		if left, right := p.channelGains(1, 0.5); !near(left, test.right/2) || !near(right, test.left/2) {
+			t.Errorf("%d%%: right channel at half volume got gains %v and %v", test.separation, left, right)
+		}
+		if pan := p.channelPan(0); !near(pan, test.pan) || !near(p.channelPan(1), -test.pan) {
+			t.Errorf("%d%%: got pans %v and %v, want %v", test.separation, pan, p.channelPan(1), test.pan)
+		}
+	}
+}
+
+// near reports whether got is within rounding of want
+func near(got, want float32) bool {
+	return got-want < 1e-4 && want-got < 1e-4
+}
+
+func TestVolumeRamp(t *testing.T) {
+	// The note on the left channel is silenced on the second row
+	module := buildSong([]uint8{0}, 1,
+		cell{sample: 1, period: 428},
+		cell{row: 1, effect: 0xc, argument: 0},
+	)
+	tests := []struct {
+		ramp uint32
+		// The channel's gain on the first sample, halfway through the
+		// ramp into the second row, and at its end
+		start, half, end float32
+	}{
+		{0, 1, 0, 0},
+		{100, 0.01, 0.49, 0},
+	}
+	for _, test := range tests {
+		p := loadSong(t, module)
+		p.VolumeRamp = test.ramp
+		channel := p.State.Channels[0]
+		p.Stream(make([][2]float32, 1))
+		if !near(channel.leftGain, test.start) {
+			t.Errorf("ramp %d: note starts at gain %v, want %v", test.ramp, channel.leftGain, test.start)
+		}
+		p.Stream(make([][2]float32, testRowSamples+50))
+		if !near(channel.leftGain, test.half) {
+			t.Errorf("ramp %d: gain %v halfway, want %v", test.ramp, channel.leftGain, test.half)
+		}
+		p.Stream(make([][2]float32, 50))
+		if !near(channel.leftGain, test.end) {
+			t.Errorf("ramp %d: gain %v at the end, want %v", test.ramp, channel.leftGain, test.end)
+		}
+	}
+}
+
+func TestDeclick(t *testing.T) {
+	// The second row replaces the note with another
+	module := buildSong([]uint8{0}, 1,
+		cell{sample: 1, period: 428},
+		cell{row: 1, sample: 2, period: 428},
+	)
+	for _, ramp := range []uint32{0, 100} {
+		p := loadSong(t, module)
+		p.VolumeRamp = ramp
+		channel := p.State.Channels[0]
+		p.Stream(make([][2]float32, testRowSamples+1))
+
+		// The old note fades out while the new one fades in
+		fading := uint32(0)
+		if ramp > 0 {
+			fading = ramp - 1
+		}
+		if channel.fade.remaining != fading || channel.SampleNum != 2 {
+			t.Errorf("ramp %d: sample %d playing with %d samples of fade left, want sample 2 with %d",
+				ramp, channel.SampleNum, channel.fade.remaining, fading)
+		}
+		if ramp > 0 && channel.fade.sample != p.Song.Samples[0] {
+			t.Errorf("ramp %d: fading the wrong sample", ramp)
+		}
+		p.Stream(make([][2]float32, int(ramp)))
+		if channel.fade.remaining != 0 || !near(channel.leftGain, 1) {
+			t.Errorf("ramp %d: %d samples of fade left and gain %v after the ramp", ramp, channel.fade.remaining, channel.leftGain)
+		}
+	}
+}
+
+func TestHeadroom(t *testing.T) {
+	tests := []struct {
+		numChannels int
+		want        float32
+	}{
+		{1, 1},
+		{4, 1},
+		{8, float32(1 / math.Sqrt2)},
+		{16, 0.5},
+	}
+	for _, test := range tests {
+		if got := headroom(test.numChannels); !near(got, test.want) {
+			t.Errorf("%d channels get gain %v, want %v", test.numChannels, got, test.want)
+		}
+	}
+}
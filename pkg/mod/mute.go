@@ -0,0 +1,92 @@
+package mod
+
+// MaxChannels is the number of channels that can be muted and soloed
+const MaxChannels = 32
+
+// audible reports whether the mixer plays a channel. While any channel is
+// soloed only the soloed ones play, otherwise every channel that isn't
+// muted does
+func (ps *PlayerState) audible(channelNum int) bool {
+	bit := uint32(1) << uint(channelNum)
+	if ps.soloMask != 0 {
+		return ps.soloMask&bit != 0
+	}
+	return !ps.muted(channelNum)
+}
+
+// muted reports whether a channel is muted, by Mute or by its Muted field
+func (ps *PlayerState) muted(channelNum int) bool {
+	return ps.muteMask&(1<<uint(channelNum)) != 0 || ps.Channels[channelNum].Muted
+}
+
+// hasChannel reports whether channelNum is one of the song's channels that
+// can be muted
+func (p *Player) hasChannel(channelNum int) bool {
+	return p.State != nil && channelNum >= 0 && channelNum < len(p.State.Channels) && channelNum < MaxChannels
+}
+
+// setBit sets or clears channelNum's bit in mask
+func setBit(mask *uint32, channelNum int, set bool) {
+	bit := uint32(1) << uint(channelNum)
+	if set {
+		*mask |= bit
+	} else {
+		*mask &^= bit
+	}
+}
+
+// Mute mutes or unmutes a channel, ignoring channels the song doesn't have
+func (p *Player) Mute(channelNum int, muted bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasChannel(channelNum) {
+		p.State.mute(channelNum, muted)
+	}
+}
+
+// mute keeps a channel's Muted field in step with the mask
+func (ps *PlayerState) mute(channelNum int, muted bool) {
+	setBit(&ps.muteMask, channelNum, muted)
+	ps.Channels[channelNum].Muted = muted
+}
+
+// ToggleMute flips the mute state of a channel
+func (p *Player) ToggleMute(channelNum int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasChannel(channelNum) {
+		p.State.mute(channelNum, !p.State.muted(channelNum))
+	}
+}
+
+// Solo soloes or unsoloes a channel. Soloed channels play on their own,
+// whether muted or not, and the mutes come back when the last is unsoloed
+func (p *Player) Solo(channelNum int, soloed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasChannel(channelNum) {
+		setBit(&p.State.soloMask, channelNum, soloed)
+	}
+}
+
+// ToggleSolo flips the solo state of a channel
+func (p *Player) ToggleSolo(channelNum int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasChannel(channelNum) {
+		p.State.soloMask ^= 1 << uint(channelNum)
+	}
+}
+
+// UnmuteAll unmutes and unsoloes every channel
+func (p *Player) UnmuteAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State != nil {
+		p.State.muteMask = 0
+		p.State.soloMask = 0
+		for idx := range p.State.Channels {
+			p.State.Channels[idx].Muted = false
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mod
+
+import "testing"
+
+func TestMuteMask(t *testing.T) {
+	// Every channel plays a note from the first row
+	var cells []cell
+	for channel := 0; channel < 4; channel++ {
+		cells = append(cells, cell{channel: channel, sample: 1, period: 428})
+	}
+	module := buildSong([]uint8{0}, 1, cells...)
+
+	tests := []struct {
+		name    string
+		change  func(p *Player)
+		muted   [4]bool
+		soloed  [4]bool
+		audible [4]bool
+	}{
+		{
+			"none", func(p *Player) {},
+			[4]bool{}, [4]bool{}, [4]bool{true, true, true, true},
+		},
+		{
+			"mute", func(p *Player) { p.Mute(1, true); p.ToggleMute(3) },
+			[4]bool{false, true, false, true}, [4]bool{}, [4]bool{true, false, true, false},
+		},
+		{
+			"unmute", func(p *Player) { p.Mute(1, true); p.Mute(1, false); p.ToggleMute(2); p.ToggleMute(2) },
+			[4]bool{}, [4]bool{}, [4]bool{true, true, true, true},
+		},
+		{
+			"solo", func(p *Player) { p.Solo(2, true) },
+			[4]bool{}, [4]bool{false, false, true, false}, [4]bool{false, false, true, false},
+		},
+		{
+			"solo overrides mute", func(p *Player) { p.Mute(0, true); p.Mute(1, true); p.ToggleSolo(0) },
+			[4]bool{true, true, false, false}, [4]bool{true, false, false, false}, [4]bool{true, false, false, false},
+		},
+		{
+			"unsolo brings mutes back", func(p *Player) { p.Mute(0, true); p.Solo(3, true); p.Solo(3, false) },
+			[4]bool{true, false, false, false}, [4]bool{}, [4]bool{false, true, true, true},
+		},
+		{
+			"unmute all", func(p *Player) { p.Mute(0, true); p.Solo(1, true); p.UnmuteAll() },
+			[4]bool{}, [4]bool{}, [4]bool{true, true, true, true},
+		},
+		{
+			"muted field", func(p *Player) { p.State.Channels[1].Muted = true; p.ToggleMute(2); p.State.Channels[2].Muted = false },
+			[4]bool{false, true, true, false}, [4]bool{}, [4]bool{true, false, false, true},
+		},
+		{
+			"toggle the muted field", func(p *Player) { p.State.Channels[1].Muted = true; p.ToggleMute(1) },
+			[4]bool{}, [4]bool{}, [4]bool{true, true, true, true},
+		},
+		{
+			"channels the song lacks", func(p *Player) { p.Mute(-1, true); p.Mute(4, true); p.Solo(MaxChannels, true) },
+			[4]bool{}, [4]bool{}, [4]bool{true, true, true, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, module)
+			test.change(p)
+			p.Stream(make([][2]float32, 2000))
+			for idx, channel := range p.Snapshot().Channels {
+				if channel.Muted != test.muted[idx] || channel.Soloed != test.soloed[idx] || channel.Audible != test.audible[idx] {
+					t.Errorf("channel %d is muted %v, soloed %v, audible %v, want %v, %v, %v", idx,
+						channel.Muted, channel.Soloed, channel.Audible, test.muted[idx], test.soloed[idx], test.audible[idx])
+				}
+				if heard := channel.Level > 0; heard != test.audible[idx] {
+					t.Errorf("channel %d heard %v, want %v", idx, heard, test.audible[idx])
+				}
+			}
+		})
+	}
+}
@@ -2,38 +2,100 @@ package mod
 
 import (
 	"errors"
-	"fmt"
 	"io"
+	"log"
+	"math"
 )
 
 // Standard controls NTSC/PAL vBlank timing
 type Standard string
 
 const (
-	pal  Standard = "PAL"
-	ntsc Standard = "NTSC"
+	// PAL is the European Amiga clock and 50 Hz vBlank
+	PAL Standard = "PAL"
+	// NTSC is the American Amiga clock and 60 Hz vBlank
+	NTSC Standard = "NTSC"
 )
 
 var clockTicksPerSecond = map[Standard]float32{
-	pal:  3546895,
-	ntsc: 3579545,
+	PAL:  3546895,
+	NTSC: 3579545,
 }
 
+var vBlanksPerSecond = map[Standard]uint32{
+	PAL:  50,
+	NTSC: 60,
+}
+
+// setStandard switches the Amiga clock used for pitch and the vBlank rate
 func (p *Player) setStandard(standard Standard) {
+	if _, ok := clockTicksPerSecond[standard]; !ok {
+		return
+	}
 	p.Standard = standard
 	p.clockTicksPerSecond = clockTicksPerSecond[standard]
-	p.clockTicksPerDeviceSample = float32(p.clockTicksPerSecond) / float32(p.SampleRate)
+	pitch := float32(math.Pow(2, float64(p.PitchShift)/12))
+	p.clockTicksPerDeviceSample = p.clockTicksPerSecond * pitch / float32(p.SampleRate)
+	p.updateTiming()
+}
+
+// The range of TempoScale and PitchShift the setters accept
+const (
+	minTempoScale = 0.25
+	maxTempoScale = 4
+	maxPitchShift = 12
+)
+
+// tempoScale returns the TempoScale, treating 0 as the song's own tempo
+func (p *Player) tempoScale() float32 {
+	if p.TempoScale <= 0 {
+		return 1
+	}
+	return p.TempoScale
+}
+
+// updateTiming recomputes the number of samples per tick from the tempo
+func (p *Player) updateTiming() {
+	if p.State == nil {
+		return
+	}
+	vBlanksPerSec := float32(p.State.Tempo) * 0.4
+	if p.VBlankTiming && !p.State.tempoSet {
+		vBlanksPerSec = float32(vBlanksPerSecond[p.Standard])
+	}
+	p.State.SamplesPerVBlank = uint32(float32(p.SampleRate) / (vBlanksPerSec * p.tempoScale()))
+}
+
+// maxStereoSeparation is the widest separation SetStereoSeparation accepts
+const maxStereoSeparation = 200
+
+func (p *Player) setMixingMode(mode MixingMode) {
+	p.MixingMode = mode
+	if mode != CustomMixingMode {
+		p.StereoSeparation = mode.Separation()
+	}
+}
+
+func (p *Player) stereoSeparation() uint32 {
+	if p.MixingMode == CustomMixingMode {
+		return p.StereoSeparation
+	}
+	return p.MixingMode.Separation()
 }
 
 // NewModPlayer instantiates the mod player
 func NewModPlayer(sampleRate uint32) *Player {
 	mp := Player{
-		SampleRate:  sampleRate,
-		SongLoaded:  false,
-		SongPlaying: false,
-		MixingMode:  StereoMixingMode,
+		SampleRate:    sampleRate,
+		SongLoaded:    false,
+		SongPlaying:   false,
+		MasterVolume:  1,
+		AutoStandard:  true,
+		Compatibility: ClassicProfile,
+		TempoScale:    1,
 	}
-	mp.setStandard(pal)
+	mp.setMixingMode(StereoMixingMode)
+	mp.setStandard(PAL)
 	return &mp
 }
 
@@ -84,7 +146,8 @@ func (p *Player) LoadModFile(f io.Reader) error {
 	minPatternRequired++
 
 	if uint32(minPatternRequired) > numPatterns {
-		fmt.Printf("Overwriting number of patterns from %d to %d", minPatternRequired, numPatterns)
+		// Logged rather than printed, stdout may carry JSON or audio
+		log.Printf("overwriting number of patterns from %d to %d", numPatterns, minPatternRequired)
 		numPatterns = uint32(minPatternRequired)
 	}
 
@@ -109,19 +172,6 @@ func (p *Player) LoadModFile(f io.Reader) error {
 	}
 	mod = nil
 
-	channels := make([]*ChannelInfo, int(format.NumChannels))
-	for idx := range channels {
-		channel := ChannelInfo{arpeggioOffsets: []uint32{0, 0}}
-		channels[idx] = &channel
-	}
-	ps := PlayerState{
-		Channels:                  channels,
-		SongSpeed:                 6,
-		NextPatternPosition:       -1,
-		NextPosition:              -1,
-		SamplesPerVBlank:          p.SampleRate / 50,
-		clockTicksPerDeviceSample: float32(clockTicksPerSecond[p.Standard]) / float32(p.SampleRate),
-	}
 	s := Song{
 		Name:             string(songName),
 		NumChannels:      format.NumChannels,
@@ -134,12 +184,46 @@ func (p *Player) LoadModFile(f io.Reader) error {
 		NumUsedPatterns:  uint32(numUsedPatterns),
 		Format:           format,
 	}
-	p.State = &ps
+	p.mu.Lock()
 	p.Song = &s
 	p.SongLoaded = true
+	p.State = newPlayerState(&s)
+
+	if p.AutoStandard {
+		// Don't keep a standard the last song hinted at
+		p.setStandard(s.autoStandard())
+	} else {
+		p.updateTiming()
+	}
+	p.State.startFirstRow()
+	p.mu.Unlock()
+
+	p.updateTimeline()
 	return nil
 }
 
+func newPlayerState(s *Song) *PlayerState {
+	channels := make([]*ChannelInfo, int(s.NumChannels))
+	for idx := range channels {
+		channel := ChannelInfo{arpeggioOffsets: []uint32{0, 0}}
+		channels[idx] = &channel
+	}
+	return &PlayerState{
+		Channels:            channels,
+		SongSpeed:           6,
+		NextPatternPosition: -1,
+		NextPosition:        -1,
+		Tempo:               defaultTempo,
+	}
+}
+
+// startFirstRow makes the first row play on the very first sample rather
+// than after a whole row of silence
+func (ps *PlayerState) startFirstRow() {
+	ps.CurrentVBlank = ps.SongSpeed
+	ps.CurrentVBlankSample = ps.SamplesPerVBlank
+}
+
 // Err tells Beep there was an error
 func (p *Player) Err() error {
 	return nil
@@ -147,11 +231,25 @@ func (p *Player) Err() error {
 
 // Stream sends samples
 func (p *Player) Stream(samples [][2]float32) (n int, ok bool) {
+	p.mu.Lock()
+	n, ok = p.stream(samples)
+	events := p.takeEvents()
+	p.mu.Unlock()
+
+	events.dispatch()
+	return n, ok
+}
+
+// stream is Stream with the player locked
+func (p *Player) stream(samples [][2]float32) (n int, ok bool) {
 	if p.State.SongHasEnded {
 		return 0, false
 	}
 	for idx := range samples {
-		left, right := p.NextSample()
+		left, right := p.nextSample()
+		if p.State.SongHasEnded {
+			return idx, idx > 0
+		}
 		samples[idx][0] = left
 		samples[idx][1] = right
 	}
@@ -160,6 +258,9 @@ func (p *Player) Stream(samples [][2]float32) (n int, ok bool) {
 
 // Play begins audio playback
 func (p *Player) Play() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if !p.SongLoaded {
 		return errors.New("no song loaded")
 	}
@@ -0,0 +1,173 @@
+package mod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// The test songs play at testSampleRate, where a tick at the default tempo
+// is 1000 samples and a row at the default speed testRowSamples
+const (
+	testSampleRate     = 50000
+	testRowSamples     = 6 * 1000
+	testPatternSamples = 64 * testRowSamples
+)
+
+// cell is a note of a test song, in channel 0 unless set otherwise
+type cell struct {
+	pattern  int
+	row      int
+	channel  int
+	sample   uint8
+	period   uint32
+	effect   uint8
+	argument uint8
+}
+
+// testSampleSizes are the sizes in bytes of the samples of the test songs,
+// looped square waves. The second is long enough to last a few rows
+var testSampleSizes = []int{64, 4096}
+
+// buildSong writes a 4 channel M.K. module that plays orders, with
+// numPatterns empty patterns except for cells
+func buildSong(orders []uint8, numPatterns int, cells ...cell) []byte {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 20))
+
+	for _, size := range testSampleSizes {
+		sample := make([]byte, 30)
+		copy(sample, "square")
+		binary.BigEndian.PutUint16(sample[22:], uint16(size/2))
+		sample[25] = 64
+		binary.BigEndian.PutUint16(sample[28:], uint16(size/2))
+		buf.Write(sample)
+	}
+	buf.Write(make([]byte, (31-len(testSampleSizes))*30))
+
+	buf.WriteByte(byte(len(orders)))
+	buf.WriteByte(127)
+	positions := make([]byte, 128)
+	copy(positions, orders)
+	buf.Write(positions)
+	buf.WriteString("M.K.")
+
+	patterns := make([]byte, numPatterns*64*4*4)
+	for _, c := range cells {
+		at := ((c.pattern*64+c.row)*4 + c.channel) * 4
+		patterns[at] = c.sample&0xf0 | byte(c.period>>8)
+		patterns[at+1] = byte(c.period)
+		patterns[at+2] = c.sample<<4 | c.effect
+		patterns[at+3] = c.argument
+	}
+	buf.Write(patterns)
+
+	for _, size := range testSampleSizes {
+		for idx := 0; idx < size; idx++ {
+			if idx%64 < 32 {
+				buf.WriteByte(0x40)
+			} else {
+				buf.WriteByte(0xc0)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// loadSong returns a playing player with the module loaded
+func loadSong(t *testing.T, module []byte) *Player {
+	t.Helper()
+	p := NewModPlayer(testSampleRate)
+	if err := p.LoadModFile(bytes.NewReader(module)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadModFile(t *testing.T) {
+	p := loadSong(t, buildSong([]uint8{0, 2, 1}, 3, cell{pattern: 2, row: 5, channel: 3, sample: 1, period: 428, effect: 0xc, argument: 32}))
+	s := p.Song
+	if s.Format.Tag != "M.K." || s.NumChannels != 4 || len(s.Samples) != 31 {
+		t.Fatalf("got format %q with %d channels and %d samples", s.Format.Tag, s.NumChannels, len(s.Samples))
+	}
+	if s.NumUsedPatterns != 3 || len(s.Patterns) != 3 {
+		t.Errorf("got %d orders and %d patterns, want 3 of each", s.NumUsedPatterns, len(s.Patterns))
+	}
+	if sample := s.Samples[0]; sample.Size() != 64 || sample.Volume() != 64 {
+		t.Errorf("got sample of %d bytes at volume %d", sample.Size(), sample.Volume())
+	}
+	want := Note{SampleNumber: 1, Period: 428, Effect: 0xc, EffectArgument: 32, NoteName: "C-2"}
+	if got := s.Patterns[2].Rows[5][3]; got != want {
+		t.Errorf("got note %+v, want %+v", got, want)
+	}
+}
+
+func TestStandard(t *testing.T) {
+	tempo := cell{effect: 0xf, argument: defaultTempo}
+	tests := []struct {
+		name     string
+		standard Standard
+		vBlank   bool
+		cells    []cell
+		clock    float32
+		tick     uint32
+	}{
+		{"pal", PAL, false, nil, 3546895, 1000},
+		{"ntsc", NTSC, false, nil, 3579545, 1000},
+		{"pal vblank", PAL, true, nil, 3546895, 1000},
+		{"ntsc vblank", NTSC, true, nil, 3579545, testSampleRate / 60},
+		// A song that sets its tempo ticks by it on any standard
+		{"ntsc vblank with tempo", NTSC, true, []cell{tempo}, 3579545, 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, buildSong([]uint8{0}, 1, test.cells...))
+			p.SetStandard(test.standard)
+			p.SetVBlankTiming(test.vBlank)
+			p.Stream(make([][2]float32, 1))
+			if got, want := p.sampleStep(428), test.clock/testSampleRate/428; !near(got, want) {
+				t.Errorf("C-2 steps %v bytes a sample, want %v", got, want)
+			}
+			if got := p.State.SamplesPerVBlank; got != test.tick {
+				t.Errorf("got %d samples a tick, want %d", got, test.tick)
+			}
+		})
+	}
+}
+
+func TestAutoStandard(t *testing.T) {
+	ntsc := buildSong([]uint8{0}, 1)
+	copy(ntsc, "tune for ntsc")
+	plain := buildSong([]uint8{0}, 1)
+
+	p := NewModPlayer(testSampleRate)
+	for _, test := range []struct {
+		module []byte
+		want   Standard
+	}{
+		{ntsc, NTSC},
+		// The next song doesn't keep the standard the last hinted at
+		{plain, PAL},
+		{ntsc, NTSC},
+	} {
+		if err := p.LoadModFile(bytes.NewReader(test.module)); err != nil {
+			t.Fatal(err)
+		}
+		if p.Standard != test.want {
+			t.Errorf("got %v, want %v", p.Standard, test.want)
+		}
+	}
+
+	// A standard picked by hand stays
+	p.AutoStandard = false
+	p.SetStandard(NTSC)
+	if err := p.LoadModFile(bytes.NewReader(plain)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Standard != NTSC {
+		t.Errorf("got %v picked by hand, want NTSC", p.Standard)
+	}
+}
@@ -0,0 +1,91 @@
+package mod
+
+// seekTime moves playback to the first row that starts at or after target
+// samples into the song. The speed, tempo and channel settings the song
+// has reached by then carry over, while the notes still sounding fade out
+// and new ones start from the row. The song is played up to there with the
+// player unlocked so the mixer isn't held up. It returns false if the song
+// ends or loops before target, or changes meanwhile, leaving playback where
+// it was
+func (p *Player) seekTime(target uint64) bool {
+	p.mu.Lock()
+	if !p.SongLoaded {
+		p.mu.Unlock()
+		return false
+	}
+	state, changes := p.State, p.timingChanges
+	sim := p.newSimulation(0)
+	p.mu.Unlock()
+
+	samples, ok := sim.playUntil(target)
+	if !ok {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State != state || p.timingChanges != changes {
+		return false
+	}
+	p.takeState(sim.State, samples)
+	return true
+}
+
+// playUntil plays the simulation p up to the first row that starts at or
+// after target samples, returning where that row starts. ok is false if
+// the song ends or loops first
+func (p *Player) playUntil(target uint64) (samples uint64, ok bool) {
+	for ticks := 0; ticks < maxSimulatedTicks; ticks++ {
+		ps := p.State
+		playsRow := ps.CurrentVBlank >= ps.SongSpeed && ps.DelayLine == 0
+		if playsRow && samples >= target {
+			return samples, true
+		}
+		p.tick()
+		if p.State.SongHasEnded || p.State.HasLooped {
+			return 0, false
+		}
+		samples += uint64(p.State.SamplesPerVBlank)
+	}
+	return 0, false
+}
+
+// takeState carries on playback from where a simulation of the song got to,
+// songPosition samples into it
+func (p *Player) takeState(sim *PlayerState, songPosition uint64) {
+	ps := p.State
+	ps.CurrentLine = sim.CurrentLine
+	ps.SongPatternPosition = sim.SongPatternPosition
+	ps.CurrentVBlank = sim.CurrentVBlank
+	ps.DelayLine = sim.DelayLine
+	ps.NextPatternPosition = sim.NextPatternPosition
+	ps.NextPosition = sim.NextPosition
+	ps.PatternLoop = sim.PatternLoop
+	ps.PatternLoopPosition = sim.PatternLoopPosition
+	ps.SetPatternPosition = sim.SetPatternPosition
+	ps.jumpingBack = sim.jumpingBack
+	ps.SongSpeed = sim.SongSpeed
+	ps.Tempo = sim.Tempo
+	ps.tempoSet = sim.tempoSet
+	ps.SongHasEnded = false
+	ps.songPosition = songPosition
+	p.updateTiming()
+	// The next sample ticks and plays the row
+	ps.CurrentVBlankSample = ps.SamplesPerVBlank
+
+	for idx, channel := range ps.Channels {
+		prev := *channel
+		*channel = *sim.Channels[idx]
+		// The simulation doesn't play samples, so a note started before
+		// the row would come in at the wrong place
+		channel.size = 0
+		channel.level = prev.level
+		channel.output = prev.output
+		channel.meter = prev.meter
+		channel.scope = prev.scope
+		channel.leftGain = prev.leftGain
+		channel.rightGain = prev.rightGain
+		channel.fade = prev.fade
+		p.fadeOut(channel, &prev)
+	}
+}
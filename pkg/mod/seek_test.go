@@ -0,0 +1,99 @@
+package mod
+
+import (
+	"testing"
+	"time"
+)
+
+// playingAt streams a sample so the row after a seek is heard, and returns
+// where the player is
+func playingAt(p *Player) (order uint32, row uint32) {
+	p.Stream(make([][2]float32, 1))
+	snapshot := p.Snapshot()
+	return snapshot.Order, snapshot.Row
+}
+
+func TestSeekTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		position time.Duration
+		ok       bool
+		elapsed  time.Duration
+		order    uint32
+		row      uint32
+	}{
+		{"start", 0, true, 0, 0, 0},
+		{"on a row", 1200 * time.Millisecond, true, 1200 * time.Millisecond, 0, 10},
+		{"between rows", 8 * time.Second, true, 8040 * time.Millisecond, 1, 3},
+		{"before the start", -time.Second, true, 0, 0, 0},
+		{"past the end", 20 * time.Second, false, 2040 * time.Millisecond, 0, 17},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, buildSong([]uint8{0, 1}, 2))
+			// Playback stays at the row after 2s when a seek fails
+			p.SeekTime(2 * time.Second)
+			if ok := p.SeekTime(test.position); ok != test.ok {
+				t.Errorf("got ok %v, want %v", ok, test.ok)
+			}
+			if got := p.Elapsed(); got != test.elapsed {
+				t.Errorf("got elapsed %v, want %v", got, test.elapsed)
+			}
+			if order, row := playingAt(p); order != test.order || row != test.row {
+				t.Errorf("playing order %d row %d, want order %d row %d", order, row, test.order, test.row)
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	// The last order is only reached by seeking to it
+	module := buildSong([]uint8{0, 1, 2}, 3,
+		cell{effect: 0xf, argument: 3},
+		cell{pattern: 1, row: 63, effect: 0xb})
+	tests := []struct {
+		name     string
+		position uint32
+		elapsed  time.Duration
+		order    uint32
+		speed    uint32
+	}{
+		{"first", 0, 0, 0, 3},
+		{"reached", 1, 3840 * time.Millisecond, 1, 3},
+		{"not reached", 2, 0, 2, 6},
+		{"out of range", 3, 0, 0, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := loadSong(t, module)
+			p.Seek(test.position)
+			if got := p.Elapsed(); got != test.elapsed {
+				t.Errorf("got elapsed %v, want %v", got, test.elapsed)
+			}
+			if order, row := playingAt(p); order != test.order || row != 0 {
+				t.Errorf("playing order %d row %d, want order %d row 0", order, row, test.order)
+			}
+			if speed := p.Snapshot().Speed; speed != test.speed {
+				t.Errorf("got speed %d, want %d", speed, test.speed)
+			}
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+	p := loadSong(t, buildSong([]uint8{0}, 1))
+	p.SetLoopCount(1)
+	p.Stream(make([][2]float32, testPatternSamples+testRowSamples))
+	if remaining, ok := p.Remaining(); !ok || remaining != testPatternSamples-testRowSamples {
+		t.Fatalf("got %d remaining after looping, want %d", remaining, testPatternSamples-testRowSamples)
+	}
+
+	// Restarting plays the loops again too
+	p.Restart()
+	if remaining, ok := p.Remaining(); !ok || remaining != 2*testPatternSamples {
+		t.Errorf("got %d remaining, want %d", remaining, 2*testPatternSamples)
+	}
+	if order, row := playingAt(p); order != 0 || row != 0 {
+		t.Errorf("playing order %d row %d, want the start", order, row)
+	}
+}
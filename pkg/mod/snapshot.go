@@ -0,0 +1,166 @@
+package mod
+
+// Snapshot is a copy of the playback state, safe to keep and read while the
+// player carries on
+type Snapshot struct {
+	// SamplePosition is the number of samples mixed when it was taken
+	SamplePosition  uint64
+	Song            *Song
+	Order           uint32
+	Pattern         uint8
+	Row             uint32
+	Tick            uint32
+	Speed           uint32
+	Tempo           uint32
+	Left            float32
+	Right           float32
+	PreLimiterLeft  float32
+	PreLimiterRight float32
+	Channels        []ChannelSnapshot
+	// The output's levels over the last block, before and after the limiter
+	LeftMeter            Meter
+	RightMeter           Meter
+	PreLimiterLeftMeter  Meter
+	PreLimiterRightMeter Meter
+}
+
+// ChannelSnapshot is the state of one channel in a Snapshot
+type ChannelSnapshot struct {
+	NoteName       string
+	SampleNum      uint8
+	Period         uint32
+	Volume         float32
+	Pan            float32
+	Effect         uint8
+	EffectArgument uint8
+	Level          float32
+	// Muted and Soloed are the channel's own settings, Audible is whether
+	// the mixer plays it with the solos taken into account
+	Muted   bool
+	Soloed  bool
+	Audible bool
+	// Playing is set while the channel's sample sounds, SamplePosition is
+	// how far into the sample it is in bytes
+	Playing        bool
+	SamplePosition float32
+	// Meter is the level of the channel's part in the output over the last
+	// block, Scope the last ScopeSize samples of it, oldest first
+	Meter Meter
+	Scope []float32
+}
+
+// Snapshot copies the current playback state. Row is the row being heard
+// rather than the next one to be played
+func (p *Player) Snapshot() Snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.SongLoaded {
+		return Snapshot{}
+	}
+
+	ps := p.State
+	tick := uint32(0)
+	if ps.CurrentVBlank > 0 {
+		tick = ps.CurrentVBlank - 1
+	}
+	snapshot := Snapshot{
+		SamplePosition:  ps.samplePosition,
+		Song:            p.Song,
+		Order:           ps.playingPosition,
+		Pattern:         p.Song.Positions[ps.playingPosition],
+		Row:             ps.playingLine,
+		Tick:            tick,
+		Speed:           ps.SongSpeed,
+		Tempo:           ps.Tempo,
+		Left:            ps.leftChannel,
+		Right:           ps.rightChannel,
+		PreLimiterLeft:  ps.preLimiterLeft,
+		PreLimiterRight: ps.preLimiterRight,
+		Channels:        make([]ChannelSnapshot, len(ps.Channels)),
+
+		LeftMeter:            ps.leftMeter.last,
+		RightMeter:           ps.rightMeter.last,
+		PreLimiterLeftMeter:  ps.preLimiterLeftMeter.last,
+		PreLimiterRightMeter: ps.preLimiterRightMeter.last,
+	}
+	for idx, channel := range ps.Channels {
+		snapshot.Channels[idx] = ChannelSnapshot{
+			NoteName:       channel.noteName,
+			SampleNum:      channel.SampleNum,
+			Period:         channel.period,
+			Volume:         channel.volume,
+			Pan:            p.channelPan(idx),
+			Effect:         channel.effect,
+			EffectArgument: channel.effectArgument,
+			Level:          channel.level,
+			Meter:          channel.meter.last,
+			Scope:          ps.scopeSnapshot(channel),
+			Muted:          ps.muted(idx),
+			Soloed:         ps.soloMask&(1<<uint(idx)) != 0,
+			Audible:        ps.audible(idx),
+			Playing:        channel.SampleNum > 0 && channel.size > 2,
+			SamplePosition: channel.samplePos,
+		}
+	}
+	return snapshot
+}
+
+// channelPan returns where a channel sits between -1 (left) and 1 (right)
+func (p *Player) channelPan(channelNum int) float32 {
+	pan := float32(p.stereoSeparation()) / 100
+	if pan > 1 {
+		pan = 1
+	}
+	outputChannel := channelNum % 4
+	if outputChannel == 0 || outputChannel == 3 {
+		return -pan
+	}
+	return pan
+}
+
+// rowHistorySize is how many rows RowAt can look back, enough to cover
+// the speaker's latency at any tempo
+const rowHistorySize = 64
+
+// rowMark records the output sample at which a row started
+type rowMark struct {
+	samplePosition uint64
+	order          uint32
+	pattern        uint8
+	row            uint32
+}
+
+func (ps *PlayerState) recordRow(pattern uint8) {
+	ps.rowHistory[ps.rowHistoryLen%rowHistorySize] = rowMark{
+		samplePosition: ps.samplePosition,
+		order:          ps.playingPosition,
+		pattern:        pattern,
+		row:            ps.playingLine,
+	}
+	ps.rowHistoryLen++
+}
+
+// RowAt returns the order, pattern and row that were playing at an earlier
+// output sample, so a display can follow what is being heard rather than
+// what was last mixed. ok is false if the row is no longer remembered
+func (p *Player) RowAt(samplePosition uint64) (order uint32, pattern uint8, row uint32, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State == nil {
+		return 0, 0, 0, false
+	}
+
+	ps := p.State
+	oldest := ps.rowHistoryLen - rowHistorySize
+	if oldest < 0 {
+		oldest = 0
+	}
+	for idx := ps.rowHistoryLen - 1; idx >= oldest; idx-- {
+		mark := ps.rowHistory[idx%rowHistorySize]
+		if mark.samplePosition <= samplePosition {
+			return mark.order, mark.pattern, mark.row, true
+		}
+	}
+	return 0, 0, 0, false
+}
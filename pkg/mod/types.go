@@ -1,16 +1,60 @@
 package mod
 
-// Player represents a mod player
+import "sync"
+
+// Player represents a mod player. Fields may be read and written directly
+// while nothing is streaming, otherwise use the methods in control.go and
+// read through View, as the mixer runs on the speaker's goroutine
 type Player struct {
-	MixingMode                MixingMode
-	SampleRate                uint32
-	SongLoaded                bool
-	SongPlaying               bool
-	Standard                  Standard
+	MixingMode MixingMode
+	// StereoSeparation in percent, used with CustomMixingMode
+	StereoSeparation uint32
+	// Crossfeed blends the left and right outputs for headphone listening
+	Crossfeed bool
+	// MasterVolume scales the mixed output, 1 leaves it unchanged
+	MasterVolume float32
+	// AutoHeadroom lowers the gain of modules with more than 4 channels
+	AutoHeadroom bool
+	// Limiter enables the soft knee limiter in front of the output, which
+	// starts to bring peaks down 4 dB below full scale and keeps them
+	// under -1 dBFS
+	Limiter     bool
+	SampleRate  uint32
+	SongLoaded  bool
+	SongPlaying bool
+	Standard    Standard
+	// VBlankTiming ticks at the vBlank rate of the Standard until the
+	// song sets a tempo, instead of the 125 BPM default
+	VBlankTiming bool
+	// AutoStandard picks the Standard from hints in the module on load
+	AutoStandard bool
+	// Compatibility picks which tracker's quirks playback follows
+	Compatibility CompatibilityProfile
+	// Interpolation picks how samples are resampled to the output rate
+	Interpolation Interpolation
+	// LoopCount is how many more times the song plays after it ends or
+	// jumps back, LoopForever never stops
+	LoopCount int
+	// TempoScale speeds up or slows down the song's ticks without changing
+	// its pitch, 1 plays at the song's own tempo
+	TempoScale float32
+	// PitchShift transposes everything by a number of semitones without
+	// changing the tempo
+	PitchShift int
+	// VolumeRamp is the number of samples over which volume and pan
+	// changes are smoothed, 0 disables ramping
+	VolumeRamp                uint32
 	Song                      *Song
 	State                     *PlayerState
 	clockTicksPerSecond       float32
 	clockTicksPerDeviceSample float32
+	mu                        sync.Mutex
+	eventHandlers             []eventSubscription
+	nextHandlerID             int
+	pendingEvents             []Event
+	// timingChanges counts changes to when rows play, so a timeline worked
+	// out before one is dropped
+	timingChanges uint64
 }
 
 // Song respresents currently loaded song
@@ -39,6 +83,33 @@ type Sample struct {
 	volume       uint8
 }
 
+// Size returns the length of the sample data in bytes
+func (s *Sample) Size() uint32 {
+	return s.size
+}
+
+// Data returns the sample's signed 8 bit PCM data, which must not be changed
+func (s *Sample) Data() []int8 {
+	return s.data
+}
+
+// Loop returns where the sample's loop starts and its length in bytes. It
+// doesn't loop if the length is 2 or less
+func (s *Sample) Loop() (start uint32, length uint32) {
+	return s.repeatOffset, s.repeatLength
+}
+
+// FineTune returns the sample's finetune in eighths of a semitone, from -8
+// to 7
+func (s *Sample) FineTune() int8 {
+	return int8(s.fineTune<<4) >> 4
+}
+
+// Volume returns the sample's default volume, from 0 to 64
+func (s *Sample) Volume() uint8 {
+	return s.volume
+}
+
 // Note defines a sample, period, and effect
 type Note struct {
 	Effect         uint8
@@ -66,32 +137,90 @@ const (
 	StereoMixingMode
 	// MonoMixingMode does what you'd expect
 	MonoMixingMode
+	// CustomMixingMode uses the player's StereoSeparation
+	CustomMixingMode
 )
 
 func (mode MixingMode) String() string {
-	return [...]string{"Amiga", "Stereo", "Mono"}[mode]
+	return [...]string{"Amiga", "Stereo", "Mono", "Custom"}[mode]
+}
+
+// Separation returns the stereo separation of a preset mode in percent
+func (mode MixingMode) Separation() uint32 {
+	switch mode {
+	case AmigaMixingMode:
+		return 100
+	case StereoMixingMode:
+		return 67
+	default:
+		return 0
+	}
 }
 
+// Interpolation defines how sample data is read between its points
+type Interpolation int
+
+const (
+	// NoInterpolation holds each sample point, like the Amiga does
+	NoInterpolation Interpolation = iota
+	// LinearInterpolation draws a straight line between sample points
+	LinearInterpolation
+)
+
+func (interpolation Interpolation) String() string {
+	return [...]string{"None", "Linear"}[interpolation]
+}
+
+// LoopForever is the LoopCount of a song that never ends
+const LoopForever = -1
+
 // PlayerState is the current state of the modplayer
 type PlayerState struct {
-	Channels                  []*ChannelInfo
-	CurrentLine               uint32
-	SongPatternPosition       uint32
-	clockTicksPerDeviceSample float32
-	CurrentVBlank             uint32
-	CurrentVBlankSample       uint32
-	DelayLine                 uint32
-	HasLooped                 bool
-	NextPatternPosition       int32
-	NextPosition              int32
-	PatternLoop               int32
-	PatternLoopPosition       *uint32
-	SamplesPerVBlank          uint32
-	SetPatternPosition        bool
-	SongHasEnded              bool
-	SongSpeed                 uint32
-	leftChannel               float32
-	rightChannel              float32
+	Channels            []*ChannelInfo
+	CurrentLine         uint32
+	SongPatternPosition uint32
+	CurrentVBlank       uint32
+	CurrentVBlankSample uint32
+	DelayLine           uint32
+	HasLooped           bool
+	jumpingBack         bool
+	loops               int
+	NextPatternPosition int32
+	NextPosition        int32
+	PatternLoop         int32
+	PatternLoopPosition *uint32
+	SamplesPerVBlank    uint32
+	SetPatternPosition  bool
+	SongHasEnded        bool
+	SongSpeed           uint32
+	started             bool
+	playingLine         uint32
+	playingPosition     uint32
+	samplePosition      uint64
+	rowHistory          [rowHistorySize]rowMark
+	rowHistoryLen       int
+	timeline            timeline
+	Tempo               uint32
+	tempoSet            bool
+	crossfeed           crossfeed
+	limiter             limiter
+	leftChannel         float32
+	rightChannel        float32
+	preLimiterLeft      float32
+	preLimiterRight     float32
+	// Block levels of the output, see measure
+	leftMeter            levelMeter
+	rightMeter           levelMeter
+	preLimiterLeftMeter  levelMeter
+	preLimiterRightMeter levelMeter
+	meterCount           int
+	scopePos             int
+	// songPosition is how far into the song playback is in samples, which
+	// unlike samplePosition follows seeks and loops
+	songPosition uint64
+	// muteMask and soloMask hold a bit for each channel muted or soloed
+	muteMask uint32
+	soloMask uint32
 }
 
 // SampleValues returns the current channel values output
@@ -99,6 +228,11 @@ func (ps *PlayerState) SampleValues() (float32, float32) {
 	return ps.leftChannel, ps.rightChannel
 }
 
+// PreLimiterSampleValues returns the current output before the limiter
+func (ps *PlayerState) PreLimiterSampleValues() (float32, float32) {
+	return ps.preLimiterLeft, ps.preLimiterRight
+}
+
 // ChannelInfo defines the current state of each output channel
 type ChannelInfo struct {
 	SampleNum        uint8
@@ -108,6 +242,8 @@ type ChannelInfo struct {
 	cutNoteDelay     uint32
 	fineTune         uint32
 	lastPortaSpeed   int32
+	lastSlideSpeed   int32
+	pendingSampleNum uint8
 	lastPortaTarget  uint32
 	noteChange       int32
 	period           uint32
@@ -124,7 +260,35 @@ type ChannelInfo struct {
 	vibratoSpeed     uint32
 	volume           float32
 	volumeChange     float32
-	Muted            bool
+	noteName         string
+	effect           uint8
+	effectArgument   uint8
+	level            float32
+	// output is the channel's part in the last output sample
+	output    float32
+	meter     levelMeter
+	scope     []float32
+	leftGain  float32
+	rightGain float32
+	fade      fadeVoice
+	// Muted is set while the channel is muted, and setting it mutes the
+	// channel too.
+	//
+	// Deprecated: use Player.Mute, which is safe to call while the song
+	// plays, and Snapshot to read whether a channel is muted
+	Muted bool
+}
+
+// fadeVoice is a note that has been cut or replaced and is being faded out
+// to avoid clicks
+type fadeVoice struct {
+	sample    *Sample
+	samplePos float32
+	size      uint32
+	period    uint32
+	leftGain  float32
+	rightGain float32
+	remaining uint32
 }
 
 // FormatDescription stores the parsed data of a particular mod format/version
@@ -0,0 +1,144 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var playlistRegexp = regexp.MustCompile(`(?i)\.(m3u8?|pls)$`)
+var plsFileRegexp = regexp.MustCompile(`(?i)^file(\d+)$`)
+
+// IsPlaylist tells whether a file name has a playlist extension
+func IsPlaylist(name string) bool {
+	return playlistRegexp.MatchString(name)
+}
+
+// Load reads an M3U, M3U8 or PLS playlist, returning its entries with
+// relative paths resolved against the playlist's directory
+func Load(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	if strings.EqualFold(filepath.Ext(path), ".pls") {
+		return ParsePLS(f, dir)
+	}
+	return ParseM3U(f, dir)
+}
+
+// ParseM3U reads an M3U or M3U8 playlist. Comments and #EXT lines are
+// skipped, relative entries are resolved against dir
+func ParseM3U(r io.Reader, dir string) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, resolve(line, dir))
+	}
+	return paths, scanner.Err()
+}
+
+// ParsePLS reads a PLS playlist, returning the FileN entries in the order
+// of their numbers. Relative entries are resolved against dir
+func ParsePLS(r io.Reader, dir string) ([]string, error) {
+	type entry struct {
+		number int
+		path   string
+	}
+	var entries []entry
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "[") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		equals := strings.IndexByte(line, '=')
+		if equals < 0 {
+			return nil, fmt.Errorf("pls line %d: missing =", lineNum)
+		}
+		match := plsFileRegexp.FindStringSubmatch(strings.TrimSpace(line[:equals]))
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("pls line %d: %v", lineNum, err)
+		}
+		entries = append(entries, entry{number, resolve(strings.TrimSpace(line[equals+1:]), dir)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].number < entries[j].number
+	})
+	paths := make([]string, len(entries))
+	for idx, entry := range entries {
+		paths[idx] = entry.path
+	}
+	return paths, nil
+}
+
+// Save writes paths as an M3U playlist. Entries inside the playlist's
+// directory are written relative to it so the list can be moved along
+// with its files
+func Save(path string, paths []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := WriteM3U(f, paths, dir); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteM3U writes paths as an extended M3U playlist, relative to dir where
+// they are inside it
+func WriteM3U(w io.Writer, paths []string, dir string) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n")
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+			if rel, err := filepath.Rel(dir, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+		bw.WriteString(filepath.ToSlash(path))
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// resolve turns a playlist entry into a path, relative to dir unless it is
+// absolute
+func resolve(entry string, dir string) string {
+	entry = strings.TrimPrefix(entry, "file://")
+	entry = filepath.FromSlash(entry)
+	if filepath.IsAbs(entry) {
+		return entry
+	}
+	return filepath.Join(dir, entry)
+}
@@ -0,0 +1,126 @@
+package playlist
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseM3U(t *testing.T) {
+	dir := filepath.FromSlash("/music")
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"empty", "", nil},
+		{"relative", "a.mod\nsub/b.mod\n", []string{"/music/a.mod", "/music/sub/b.mod"}},
+		{"absolute", "/other/c.mod\n", []string{"/other/c.mod"}},
+		{"file url", "file:///other/c.mod\n", []string{"/other/c.mod"}},
+		{"extended", "#EXTM3U\n#EXTINF:123,Title\na.mod\n", []string{"/music/a.mod"}},
+		{"blank lines and spaces", "\n  a.mod  \n\n", []string{"/music/a.mod"}},
+		{"byte order mark", "\ufeffa.mod\r\nb.mod\r\n", []string{"/music/a.mod", "/music/b.mod"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseM3U(strings.NewReader(test.input), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fromSlash(test.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	dir := filepath.FromSlash("/music")
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "[playlist]\n", []string{}, false},
+		{
+			"numbered",
+			"[playlist]\nFile1=a.mod\nTitle1=A\nFile2=/other/b.mod\nNumberOfEntries=2\nVersion=2\n",
+			[]string{"/music/a.mod", "/other/b.mod"},
+			false,
+		},
+		{
+			"out of order",
+			"[playlist]\nFile10=c.mod\nfile2=b.mod\nFILE1=a.mod\n",
+			[]string{"/music/a.mod", "/music/b.mod", "/music/c.mod"},
+			false,
+		},
+		{"comments", "; comment\n[playlist]\nFile1 = a.mod \n", []string{"/music/a.mod"}, false},
+		{"missing equals", "[playlist]\nFile1\n", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParsePLS(strings.NewReader(test.input), dir)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if want := fromSlash(test.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIsPlaylist(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"list.m3u", true},
+		{"list.M3U8", true},
+		{"list.pls", true},
+		{"song.mod", false},
+		{"m3u", false},
+	}
+	for _, test := range tests {
+		if got := IsPlaylist(test.name); got != test.want {
+			t.Errorf("IsPlaylist(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "a.mod"),
+		filepath.Join(dir, "sub", "b.mod"),
+		filepath.Join(filepath.Dir(dir), "outside.mod"),
+	}
+	path := filepath.Join(dir, "queue.m3u")
+	if err := Save(path, paths); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, paths) {
+		t.Errorf("got %q, want %q", got, paths)
+	}
+}
+
+// fromSlash converts the expected paths of a test to the system's form
+func fromSlash(paths []string) []string {
+	if paths == nil {
+		return nil
+	}
+	converted := make([]string, len(paths))
+	for idx, path := range paths {
+		converted[idx] = filepath.FromSlash(path)
+	}
+	return converted
+}
@@ -0,0 +1,223 @@
+package playlist
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RepeatMode defines what the queue does after its last entry
+type RepeatMode int
+
+const (
+	// RepeatOff stops after the last entry
+	RepeatOff RepeatMode = iota
+	// RepeatOne plays the current entry over and over
+	RepeatOne
+	// RepeatAll starts again from the first entry
+	RepeatAll
+)
+
+func (mode RepeatMode) String() string {
+	return [...]string{"Off", "One", "All"}[mode]
+}
+
+// Queue is the list of files to play. It is safe to use from several
+// goroutines, as the speaker asks for the next entry from its own
+type Queue struct {
+	mu      sync.Mutex
+	entries []string
+	// order holds indexes into entries in the order they play
+	order []int
+	pos   int
+	// given is set once the entry at pos has been given out by Next
+	given bool
+	// pinned is set while the entry after pos is the one Set made next
+	pinned  bool
+	shuffle bool
+	repeat  RepeatMode
+	rng     *rand.Rand
+}
+
+// NewQueue returns an empty queue
+func NewQueue() *Queue {
+	return &Queue{
+		pos: -1,
+		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Set replaces the entries, making start the next one to play
+func (q *Queue) Set(paths []string, start int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = append([]string(nil), paths...)
+	q.given = false
+	q.pinned = true
+	q.order = make([]int, len(paths))
+	for idx := range q.order {
+		q.order[idx] = idx
+	}
+	if start < 0 || start >= len(paths) {
+		start = 0
+	}
+	if q.shuffle {
+		q.pos = -1
+		q.shuffleFrom(0)
+		for idx, entry := range q.order {
+			if entry == start {
+				q.order[0], q.order[idx] = q.order[idx], q.order[0]
+				break
+			}
+		}
+	} else {
+		q.pos = start - 1
+	}
+}
+
+// Add appends paths to the queue. With shuffle on they are spread among
+// the entries that haven't played yet, after the one Set made next
+func (q *Queue) Add(paths ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	first := q.unplayed()
+	for _, path := range paths {
+		q.entries = append(q.entries, path)
+		entry := len(q.entries) - 1
+		if !q.shuffle {
+			q.order = append(q.order, entry)
+			continue
+		}
+		at := first + q.rng.Intn(len(q.order)-first+1)
+		q.order = append(q.order, 0)
+		copy(q.order[at+1:], q.order[at:])
+		q.order[at] = entry
+	}
+}
+
+// Next moves to the entry that plays next and returns it, ok is false once
+// the queue is done
+func (q *Queue) Next() (path string, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return "", false
+	}
+	switch {
+	case q.repeat == RepeatOne && q.given:
+	case q.pos+1 < len(q.order):
+		q.pos++
+	case q.repeat == RepeatAll:
+		q.pos = 0
+		if q.shuffle {
+			q.shuffleFrom(0)
+		}
+	default:
+		return "", false
+	}
+	q.given = true
+	q.pinned = false
+	return q.entries[q.order[q.pos]], true
+}
+
+// Entries returns the paths in the order they were added
+func (q *Queue) Entries() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.entries...)
+}
+
+// Len returns the number of entries
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Position returns the place of the last entry given out in the play
+// order, counting from 1, or 0 before the first
+func (q *Queue) Position() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pos + 1
+}
+
+// SetPosition makes position, counted like Position, the place of the
+// last entry given out, so the one after it plays next
+func (q *Queue) SetPosition(position int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if position < 0 {
+		position = 0
+	}
+	if position > len(q.order) {
+		position = len(q.order)
+	}
+	q.pos = position - 1
+	q.given = position > 0
+	q.pinned = false
+}
+
+// Shuffle tells whether the entries play in a random order
+func (q *Queue) Shuffle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuffle
+}
+
+// SetShuffle turns random order on or off. The entries already played stay
+// where they are
+func (q *Queue) SetShuffle(shuffle bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if shuffle == q.shuffle {
+		return
+	}
+	q.shuffle = shuffle
+	if shuffle {
+		q.shuffleFrom(q.unplayed())
+		return
+	}
+	current := -1
+	if q.pos >= 0 {
+		current = q.order[q.pos]
+	}
+	for idx := range q.order {
+		q.order[idx] = idx
+	}
+	q.pos = current
+}
+
+// Repeat returns what happens after the last entry
+func (q *Queue) Repeat() RepeatMode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.repeat
+}
+
+// SetRepeat sets what happens after the last entry
+func (q *Queue) SetRepeat(mode RepeatMode) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if mode >= RepeatOff && mode <= RepeatAll {
+		q.repeat = mode
+	}
+}
+
+// unplayed returns the index in the play order of the first entry that may
+// be moved, leaving out the one Set made next
+func (q *Queue) unplayed() int {
+	first := q.pos + 1
+	if q.pinned && first < len(q.order) {
+		first++
+	}
+	return first
+}
+
+// shuffleFrom shuffles the play order from index start on
+func (q *Queue) shuffleFrom(start int) {
+	rest := q.order[start:]
+	q.rng.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+}
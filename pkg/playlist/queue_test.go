@@ -0,0 +1,150 @@
+package playlist
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// take returns the next count entries of q, stopping early when it is done
+func take(q *Queue, count int) []string {
+	var paths []string
+	for idx := 0; idx < count; idx++ {
+		path, ok := q.Next()
+		if !ok {
+			break
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestQueueOrder(t *testing.T) {
+	paths := []string{"a", "b", "c"}
+	tests := []struct {
+		name   string
+		start  int
+		repeat RepeatMode
+		count  int
+		want   []string
+	}{
+		{"repeat off", 0, RepeatOff, 5, []string{"a", "b", "c"}},
+		{"from start", 1, RepeatOff, 5, []string{"b", "c"}},
+		{"start out of range", 7, RepeatOff, 5, []string{"a", "b", "c"}},
+		{"repeat one", 1, RepeatOne, 3, []string{"b", "b", "b"}},
+		{"repeat all", 1, RepeatAll, 5, []string{"b", "c", "a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := NewQueue()
+			q.Set(paths, test.start)
+			q.SetRepeat(test.repeat)
+			if got := take(q, test.count); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueueEmpty(t *testing.T) {
+	q := NewQueue()
+	q.SetRepeat(RepeatAll)
+	if path, ok := q.Next(); ok {
+		t.Errorf("empty queue gave %q", path)
+	}
+}
+
+func TestQueueShuffle(t *testing.T) {
+	paths := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	tests := []struct {
+		name  string
+		start int
+		added []string
+	}{
+		{"from first", 0, nil},
+		{"from start", 5, nil},
+		{"added while shuffled", 0, []string{"i", "j"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := NewQueue()
+			q.SetShuffle(true)
+			q.Set(paths, test.start)
+			q.Add(test.added...)
+			q.SetRepeat(RepeatAll)
+
+			all := append(append([]string(nil), paths...), test.added...)
+			first := take(q, len(all))
+			if first[0] != paths[test.start] {
+				t.Errorf("played %q first, want %q", first[0], paths[test.start])
+			}
+			// Each pass plays every entry once
+			for pass, got := range [][]string{first, take(q, len(all))} {
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, all) {
+					t.Errorf("pass %d played %q, want each of %q once", pass, got, all)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueShuffleOff(t *testing.T) {
+	q := NewQueue()
+	q.SetShuffle(true)
+	q.Set([]string{"a", "b", "c", "d"}, 2)
+	take(q, 1)
+	q.SetShuffle(false)
+	// Unshuffling carries on in order after the entry playing
+	if got, want := take(q, 5), []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestQueueSetPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		played   int
+		position int
+		want     []string
+	}{
+		{"back one", 2, 1, []string{"b", "c"}},
+		{"to the start", 3, 0, []string{"a", "b", "c"}},
+		{"below the start", 1, -4, []string{"a", "b", "c"}},
+		{"past the end", 1, 9, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := NewQueue()
+			q.Set([]string{"a", "b", "c"}, 0)
+			take(q, test.played)
+			q.SetPosition(test.position)
+			if got := take(q, 5); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueueShuffleAfterSet(t *testing.T) {
+	q := NewQueue()
+	q.Set([]string{"a", "b", "c", "d", "e", "f"}, 3)
+	q.SetShuffle(true)
+	if got := take(q, 1); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Errorf("played %q first, want %q", got, "d")
+	}
+}
+
+func TestQueueShuffleAdded(t *testing.T) {
+	// Without Set nothing is kept first, so the first entry added can't
+	// always play first
+	for try := 0; try < 50; try++ {
+		q := NewQueue()
+		q.SetShuffle(true)
+		q.Add("a", "b", "c", "d", "e", "f", "g", "h")
+		if got := take(q, 1); got[0] != "a" {
+			return
+		}
+	}
+	t.Error("the first entry added always played first")
+}
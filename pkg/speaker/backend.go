@@ -0,0 +1,126 @@
+package speaker
+
+import (
+	"io"
+	"os"
+)
+
+// SampleFormat is how each sample is encoded
+type SampleFormat int
+
+const (
+	// Int16 is signed 16 bit PCM
+	Int16 SampleFormat = iota
+	// Int24 is signed 24 bit PCM packed into 3 bytes
+	Int24
+	// Float32 is IEEE floating point between -1 and 1
+	Float32
+)
+
+func (f SampleFormat) String() string {
+	return [...]string{"int16", "int24", "float32"}[f]
+}
+
+// BytesPerSample returns the size of one encoded sample
+func (f SampleFormat) BytesPerSample() int {
+	return [...]int{2, 3, 4}[f]
+}
+
+// Format describes the PCM data a Backend receives. Samples are little
+// endian and interleaved
+type Format struct {
+	SampleRate   uint32
+	NumChannels  int
+	SampleFormat SampleFormat
+}
+
+// BytesPerSample returns the size of one encoded sample
+func (f Format) BytesPerSample() int {
+	return f.SampleFormat.BytesPerSample()
+}
+
+// FrameSize returns the size in bytes of one sample for every channel
+func (f Format) FrameSize() int {
+	return f.NumChannels * f.BytesPerSample()
+}
+
+// Backend is where a Speaker sends its audio
+type Backend interface {
+	// Open prepares the backend for PCM data in format, written in blocks
+	// of up to bufferSize bytes
+	Open(format Format, bufferSize int) error
+	io.Writer
+	// Close flushes and releases the backend
+	Close() error
+}
+
+// BufferedBackend is a Backend that holds on to written audio before it is
+// heard, like a sound card
+type BufferedBackend interface {
+	Backend
+	// Buffered returns the number of bytes written but not yet heard
+	Buffered() int
+}
+
+// PCMBackend writes raw PCM to a writer, for piping into other tools
+type PCMBackend struct {
+	w io.Writer
+	// f is the file the backend created, if it did
+	f *os.File
+}
+
+// NewPCMBackend returns a backend writing raw PCM to w. w is left open
+func NewPCMBackend(w io.Writer) *PCMBackend {
+	return &PCMBackend{w: w}
+}
+
+// CreatePCMBackend returns a backend writing raw PCM to a new file at
+// path, which it closes along with itself
+func CreatePCMBackend(path string) (*PCMBackend, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &PCMBackend{w: f, f: f}, nil
+}
+
+// Open does nothing, raw PCM has no header
+func (b *PCMBackend) Open(format Format, bufferSize int) error {
+	return nil
+}
+
+func (b *PCMBackend) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
+
+// Close closes the file the backend created. Writers given to
+// NewPCMBackend stay open
+func (b *PCMBackend) Close() error {
+	if b.f != nil {
+		return b.f.Close()
+	}
+	return nil
+}
+
+// NullBackend throws the audio away as fast as it comes, which is handy
+// for tests and benchmarks
+type NullBackend struct{}
+
+// NewNullBackend returns a backend that discards everything
+func NewNullBackend() *NullBackend {
+	return &NullBackend{}
+}
+
+// Open does nothing
+func (b *NullBackend) Open(format Format, bufferSize int) error {
+	return nil
+}
+
+func (b *NullBackend) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Close does nothing
+func (b *NullBackend) Close() error {
+	return nil
+}
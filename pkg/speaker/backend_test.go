@@ -0,0 +1,51 @@
+package speaker
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// closeRecorder is a buffer that records being closed
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPCMBackendClose(t *testing.T) {
+	w := &closeRecorder{}
+	b := NewPCMBackend(w)
+	if _, err := b.Write([]byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.closed || w.Len() != 2 {
+		t.Errorf("given writer closed %v with %d bytes, want left open with 2", w.closed, w.Len())
+	}
+
+	path := filepath.Join(t.TempDir(), "out.pcm")
+	created, err := CreatePCMBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := created.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := created.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := created.Write([]byte{4}); err == nil {
+		t.Error("created file still open after Close")
+	}
+	if data, err := os.ReadFile(path); err != nil || len(data) != 3 {
+		t.Errorf("read %d bytes back, %v, want 3", len(data), err)
+	}
+}
@@ -0,0 +1,57 @@
+package speaker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hajimehoshi/oto"
+)
+
+// OtoBackend plays through the system's audio device
+type OtoBackend struct {
+	context    *oto.Context
+	player     *oto.Player
+	bufferSize int
+}
+
+// NewOtoBackend returns a backend for the default audio device
+func NewOtoBackend() *OtoBackend {
+	return &OtoBackend{}
+}
+
+// Open opens the audio device
+func (b *OtoBackend) Open(format Format, bufferSize int) error {
+	if format.SampleFormat != Int16 {
+		return fmt.Errorf("the audio device only supports int16 output, not %s", format.SampleFormat)
+	}
+	context, err := oto.NewContext(int(format.SampleRate), format.NumChannels, format.BytesPerSample(), bufferSize)
+	if err != nil {
+		return errors.New(("Could not initialise speaker"))
+	}
+	b.context = context
+	b.player = context.NewPlayer()
+	b.bufferSize = bufferSize
+	return nil
+}
+
+// Buffered returns the size of the device buffer, which a blocking Write
+// keeps full while playing
+func (b *OtoBackend) Buffered() int {
+	return b.bufferSize
+}
+
+func (b *OtoBackend) Write(p []byte) (int, error) {
+	return b.player.Write(p)
+}
+
+// Close closes the audio device
+func (b *OtoBackend) Close() error {
+	if b.player == nil {
+		return nil
+	}
+	b.player.Close()
+	err := b.context.Close()
+	b.player = nil
+	b.context = nil
+	return err
+}
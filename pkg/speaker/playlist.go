@@ -0,0 +1,223 @@
+package speaker
+
+import (
+	"math"
+	"sync"
+)
+
+// RemainingStreamer is a Streamer that knows how many samples it has left,
+// which a Playlist needs to start a crossfade before the end
+type RemainingStreamer interface {
+	Streamer
+	Remaining() (samples int, ok bool)
+}
+
+// Playlist is a Streamer that plays streamers one after another without a
+// gap, optionally crossfading between them. The next streamer is loaded in
+// the background while the current one plays
+type Playlist struct {
+	mu        sync.Mutex
+	next      func() Streamer
+	preload   chan Streamer
+	current   Streamer
+	incoming  Streamer
+	crossfade int
+	fadePos   int
+	fadeLen   int
+	lastSong  bool
+	onChange  func(Streamer)
+	buf       [][2]float32
+}
+
+// NewPlaylist returns a playlist that gets its streamers from next, which
+// returns nil when there are no more. crossfade is the length of the
+// crossfade in samples, 0 for a plain gapless switch
+func NewPlaylist(next func() Streamer, crossfade int) *Playlist {
+	pl := &Playlist{
+		next:      next,
+		crossfade: crossfade,
+	}
+	pl.startPreload()
+	return pl
+}
+
+// OnChange sets a function called with each streamer as it becomes the
+// current one. It runs on the speaker's goroutine and must not call back
+// into the playlist
+func (pl *Playlist) OnChange(fn func(Streamer)) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.onChange = fn
+}
+
+// Err returns the error of the current streamer
+func (pl *Playlist) Err() error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.current == nil {
+		return nil
+	}
+	return pl.current.Err()
+}
+
+// Stream fills samples from the current streamer, moving on to the next
+// one as soon as it runs out
+func (pl *Playlist) Stream(samples [][2]float32) (n int, ok bool) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	for n < len(samples) {
+		if pl.current == nil && !pl.advance() {
+			break
+		}
+		chunk := samples[n:]
+
+		if pl.incoming != nil {
+			n += pl.mix(chunk)
+			continue
+		}
+
+		if remaining, known := pl.remaining(); known {
+			if remaining <= pl.crossfade {
+				pl.startCrossfade(remaining)
+				continue
+			}
+			if untilFade := remaining - pl.crossfade; untilFade < len(chunk) {
+				chunk = chunk[:untilFade]
+			}
+		}
+
+		streamed, ok := pl.current.Stream(chunk)
+		n += streamed
+		if !ok || streamed < len(chunk) {
+			pl.current = nil
+		}
+	}
+	return n, n > 0
+}
+
+// remaining returns how far the current streamer is from the point where
+// the crossfade has to start
+func (pl *Playlist) remaining() (int, bool) {
+	if pl.crossfade == 0 || pl.lastSong {
+		return 0, false
+	}
+	streamer, ok := pl.current.(RemainingStreamer)
+	if !ok {
+		return 0, false
+	}
+	return streamer.Remaining()
+}
+
+// Reload runs change, which alters what plays after the current streamer,
+// and loads the next streamer again to follow it. change is told whether
+// the preloaded streamer was dropped, so whatever next takes from can go
+// back to where the current one came from. During a crossfade the incoming
+// streamer is already heard and is kept
+func (pl *Playlist) Reload(change func(dropped bool)) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.current == nil || pl.incoming != nil {
+		change(false)
+		return
+	}
+	pl.take()
+	change(true)
+	pl.lastSong = false
+	pl.startPreload()
+}
+
+func (pl *Playlist) startPreload() {
+	preload := make(chan Streamer, 1)
+	next := pl.next
+	go func() {
+		preload <- next()
+	}()
+	pl.preload = preload
+}
+
+// take returns the preloaded streamer, waiting for it if it isn't ready
+func (pl *Playlist) take() Streamer {
+	if pl.preload == nil {
+		return nil
+	}
+	s := <-pl.preload
+	pl.preload = nil
+	return s
+}
+
+// advance makes the preloaded streamer the current one
+func (pl *Playlist) advance() bool {
+	s := pl.take()
+	if s == nil {
+		return false
+	}
+	pl.setCurrent(s)
+	return true
+}
+
+func (pl *Playlist) setCurrent(s Streamer) {
+	pl.current = s
+	pl.lastSong = false
+	pl.startPreload()
+	if pl.onChange != nil {
+		pl.onChange(s)
+	}
+}
+
+func (pl *Playlist) startCrossfade(remaining int) {
+	pl.incoming = pl.take()
+	if pl.incoming == nil {
+		pl.lastSong = true
+		return
+	}
+	pl.fadePos = 0
+	pl.fadeLen = remaining
+}
+
+// mix streams both sides of a crossfade into chunk with equal power gains
+// and returns the number of samples written
+func (pl *Playlist) mix(chunk [][2]float32) int {
+	if left := pl.fadeLen - pl.fadePos; left < len(chunk) {
+		chunk = chunk[:left]
+	}
+	if len(pl.buf) < len(chunk) {
+		pl.buf = make([][2]float32, len(chunk))
+	}
+	in := pl.buf[:len(chunk)]
+
+	outgoing := 0
+	if pl.current != nil {
+		var ok bool
+		outgoing, ok = pl.current.Stream(chunk)
+		if !ok {
+			outgoing = 0
+		}
+	}
+	for i := outgoing; i < len(chunk); i++ {
+		chunk[i] = [2]float32{}
+	}
+	incoming, ok := pl.incoming.Stream(in)
+	if !ok {
+		incoming = 0
+	}
+	for i := incoming; i < len(in); i++ {
+		in[i] = [2]float32{}
+	}
+
+	for i := range chunk {
+		t := float64(pl.fadePos+i) / float64(pl.fadeLen)
+		outGain := float32(math.Cos(t * math.Pi / 2))
+		inGain := float32(math.Sin(t * math.Pi / 2))
+		chunk[i][0] = chunk[i][0]*outGain + in[i][0]*inGain
+		chunk[i][1] = chunk[i][1]*outGain + in[i][1]*inGain
+	}
+	pl.fadePos += len(chunk)
+
+	if pl.fadePos >= pl.fadeLen {
+		incomingStreamer := pl.incoming
+		pl.incoming = nil
+		pl.setCurrent(incomingStreamer)
+	}
+	return len(chunk)
+}
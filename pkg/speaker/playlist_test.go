@@ -0,0 +1,127 @@
+package speaker
+
+import (
+	"os"
+	"testing"
+
+	"github.com/zeozeozeo/gomodplay/pkg/mod"
+)
+
+// constant streams count samples of value
+type constant struct {
+	count int
+	value float32
+}
+
+func (c *constant) Stream(samples [][2]float32) (n int, ok bool) {
+	for n < len(samples) && c.count > 0 {
+		samples[n] = [2]float32{c.value, c.value}
+		n++
+		c.count--
+	}
+	return n, n > 0
+}
+
+func (c *constant) Err() error {
+	return nil
+}
+
+// loadSong returns a player of one of the repository's example modules at
+// a low rate, to keep the test quick
+func loadSong(t *testing.T, loopCount int) *mod.Player {
+	t.Helper()
+	f, err := os.Open("../../modfiles/CANNONFO.MOD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := mod.NewModPlayer(4000)
+	if err := p.LoadModFile(f); err != nil {
+		t.Fatal(err)
+	}
+	p.SetLoopCount(loopCount)
+	p.Play()
+	return p
+}
+
+func TestPlaylistCrossfadeLoopingSong(t *testing.T) {
+	const crossfade, nextLength = 1000, 3000
+	tests := []struct {
+		name      string
+		loopCount int
+	}{
+		{"once", 0},
+		{"looped", 1},
+		{"looped twice", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			song := loadSong(t, test.loopCount)
+			songLength := int(song.Length()) * (test.loopCount + 1)
+			streamers := []Streamer{song, &constant{nextLength, 1}}
+			pl := NewPlaylist(func() Streamer {
+				if len(streamers) == 0 {
+					return nil
+				}
+				s := streamers[0]
+				streamers = streamers[1:]
+				return s
+			}, crossfade)
+
+			// The next song takes over once the last loop is over, having
+			// faded in over its end
+			changed := false
+			pl.OnChange(func(s Streamer) {
+				if _, ok := s.(*constant); ok {
+					changed = true
+				}
+			})
+			buf := make([][2]float32, 512)
+			streamed, changedBy := 0, -1
+			for {
+				n, ok := pl.Stream(buf)
+				if !ok {
+					break
+				}
+				streamed += n
+				if changed && changedBy == -1 {
+					changedBy = streamed
+				}
+			}
+			if changedBy < songLength || changedBy >= songLength+len(buf) {
+				t.Errorf("next song took over by %d samples, want at %d", changedBy, songLength)
+			}
+			if want := songLength + nextLength - crossfade; streamed != want {
+				t.Errorf("streamed %d samples, want %d", streamed, want)
+			}
+		})
+	}
+}
+
+func TestPlaylistLoopingForever(t *testing.T) {
+	song := loadSong(t, mod.LoopForever)
+	length := int(song.Length())
+	next := 0
+	pl := NewPlaylist(func() Streamer {
+		next++
+		if next == 1 {
+			return song
+		}
+		return &constant{1000, 1}
+	}, 1000)
+
+	// A song that loops forever is never faded out
+	buf := make([][2]float32, 4096)
+	for streamed := 0; streamed < 2*length; {
+		n, ok := pl.Stream(buf)
+		if !ok {
+			t.Fatalf("playlist ended after %d samples", streamed)
+		}
+		streamed += n
+		for _, sample := range buf[:n] {
+			if sample == [2]float32{1, 1} {
+				t.Fatalf("next song heard after %d samples", streamed)
+			}
+		}
+	}
+}
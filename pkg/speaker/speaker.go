@@ -3,10 +3,13 @@ package speaker
 // Ripped out of https://github.com/faiface/beep
 
 import (
+	"encoding/binary"
 	"errors"
+	"math"
+	"math/rand"
 	"sync"
-
-	"github.com/hajimehoshi/oto"
+	"sync/atomic"
+	"time"
 )
 
 // Streamer provides the interface to stream samples
@@ -15,102 +18,253 @@ type Streamer interface {
 	Err() error
 }
 
-var (
+// Speaker pulls samples from a Streamer on its own goroutine and writes
+// them to a Backend. Several speakers can run side by side
+type Speaker struct {
+	// frame counters are first for 64 bit alignment on 32 bit platforms
+	framesStreamed uint64
+	framesWritten  uint64
+	lastWrite      int64
+
 	mu       sync.Mutex
+	backend  Backend
+	format   Format
 	samples  [][2]float32
 	buf      []byte
-	context  *oto.Context
-	player   *oto.Player
+	streamer Streamer
+	callback func()
+	dither   bool
+	rng      *rand.Rand
+	err      error
+	wake     chan struct{}
 	done     chan struct{}
-	streamer *Streamer
-	callback *func()
-)
+	stopped  chan struct{}
+	tap      tap
 
-// Init initializes audio playback through speaker. Must be called before using this package.
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New starts a speaker writing to backend in the given format. Stereo
+// streams are mixed down when format has a single channel.
 //
 // The bufferSize argument specifies the number of samples of the speaker's buffer. Bigger
 // bufferSize means lower CPU usage and more reliable playback. Lower bufferSize means better
 // responsiveness and less delay.
-func Init(sampleRate uint32, bufferSize uint32) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	Close()
-
-	numBytes := int(bufferSize * 4)
-	samples = make([][2]float32, bufferSize)
-	buf = make([]byte, numBytes)
-
-	var err error
-	context, err = oto.NewContext(int(sampleRate), 2, 2, numBytes)
-	if err != nil {
-		return errors.New(("Could not initialise speaker"))
+func New(backend Backend, format Format, bufferSize uint32) (*Speaker, error) {
+	if format.NumChannels != 1 && format.NumChannels != 2 {
+		return nil, errors.New("speaker only supports mono and stereo output")
+	}
+	if err := backend.Open(format, int(bufferSize)*format.FrameSize()); err != nil {
+		return nil, err
 	}
-	player = context.NewPlayer()
 
-	done = make(chan struct{})
+	sp := &Speaker{
+		backend: backend,
+		format:  format,
+		samples: make([][2]float32, bufferSize),
+		buf:     make([]byte, int(bufferSize)*format.FrameSize()),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
 
 	go func() {
+		defer close(sp.stopped)
 		for {
 			select {
-			default:
-				update()
-			case <-done:
+			case <-sp.done:
 				return
+			case <-sp.wake:
+				for sp.update() {
+					select {
+					case <-sp.done:
+						return
+					default:
+					}
+				}
 			}
 		}
 	}()
 
-	return nil
+	return sp, nil
 }
 
-// Close down everything
-func Close() {
-	if player != nil {
-		if done != nil {
-			done <- struct{}{}
-			done = nil
-		}
-		player.Close()
-		context.Close()
-		player = nil
-	}
+// Close stops playback and closes the backend. Later calls do nothing and
+// return the same error
+func (sp *Speaker) Close() error {
+	sp.closeOnce.Do(func() {
+		close(sp.done)
+		<-sp.stopped
+		sp.closeErr = sp.backend.Close()
+	})
+	return sp.closeErr
 }
 
-// Play some music
-func Play(s Streamer, callback func()) {
-	mu.Lock()
-	streamer = &s
-	mu.Unlock()
+// Err returns the error that stopped the backend, if any
+func (sp *Speaker) Err() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.err
 }
 
-func update() {
-	mu.Lock()
-	numSamples, ok := (*streamer).Stream(samples)
-	mu.Unlock()
+// Play some music. callback is called from the speaker's goroutine once s
+// runs out of samples or the backend fails, after which the speaker idles
+// until the next call to Play
+func (sp *Speaker) Play(s Streamer, callback func()) {
+	sp.mu.Lock()
+	sp.streamer = s
+	sp.callback = callback
+	sp.mu.Unlock()
+
+	select {
+	case sp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// update streams one buffer to the backend, returning false once there is
+// nothing left to play. The lock is let go while the backend is written
+// to, as that blocks until the backend has room
+func (sp *Speaker) update() bool {
+	sp.mu.Lock()
+	streamer := sp.streamer
+	if streamer == nil {
+		sp.mu.Unlock()
+		return false
+	}
+
+	numSamples, ok := streamer.Stream(sp.samples)
+	var data []byte
+	if ok {
+		atomic.AddUint64(&sp.framesStreamed, uint64(numSamples))
+		sp.tap.write(sp.samples[:numSamples])
+		data = sp.encode(numSamples)
+	}
+	sp.mu.Unlock()
+
+	var err error
+	if ok {
+		_, err = sp.backend.Write(data)
+		atomic.StoreInt64(&sp.lastWrite, time.Now().UnixNano())
+		atomic.AddUint64(&sp.framesWritten, uint64(numSamples))
+	}
+	if ok && err == nil {
+		return true
+	}
+
+	// Play may have started something else while the backend was written to
+	sp.mu.Lock()
+	if sp.streamer != streamer {
+		sp.mu.Unlock()
+		return true
+	}
+	finished := sp.callback
+	sp.streamer = nil
+	sp.callback = nil
+	sp.err = err
+	sp.mu.Unlock()
+
+	if finished != nil {
+		finished()
+	}
+	return false
+}
 
+// FramesPlayed returns how many frames have been heard since the speaker
+// started. For a device this is estimated from its buffer and the time
+// since the last write
+func (sp *Speaker) FramesPlayed() uint64 {
+	written := atomic.LoadUint64(&sp.framesWritten)
+	buffered, ok := sp.backend.(BufferedBackend)
 	if !ok {
-		Close()
-		(*callback)()
-		return
+		return written
 	}
 
+	bufferedFrames := uint64(buffered.Buffered() / sp.format.FrameSize())
+	if bufferedFrames > written {
+		bufferedFrames = written
+	}
+	played := written - bufferedFrames
+
+	lastWrite := atomic.LoadInt64(&sp.lastWrite)
+	if lastWrite != 0 {
+		elapsed := time.Since(time.Unix(0, lastWrite))
+		played += uint64(elapsed.Seconds() * float64(sp.format.SampleRate))
+	}
+	if played > written {
+		played = written
+	}
+	return played
+}
+
+// Latency returns how long it takes for a sample taken from the streamer
+// to be heard
+func (sp *Speaker) Latency() time.Duration {
+	streamed := atomic.LoadUint64(&sp.framesStreamed)
+	played := sp.FramesPlayed()
+	if played > streamed {
+		return 0
+	}
+	return time.Duration(streamed-played) * time.Second / time.Duration(sp.format.SampleRate)
+}
+
+// SetDither turns TPDF dither on integer output on or off
+func (sp *Speaker) SetDither(enabled bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.dither = enabled
+}
+
+// encode converts the first numSamples samples to the output format
+func (sp *Speaker) encode(numSamples int) []byte {
+	size := sp.format.BytesPerSample()
+	offset := 0
 	for i := 0; i < numSamples; i++ {
-		for c := range samples[i] {
-			val := samples[i][c]
-			if val < -1 {
-				val = -1
-			}
-			if val > +1 {
-				val = +1
-			}
-			valInt16 := int16(val * (1<<15 - 1))
-			low := byte(valInt16)
-			high := byte(valInt16 >> 8)
-			buf[i*4+c*2+0] = low
-			buf[i*4+c*2+1] = high
+		frame := sp.samples[i]
+		if sp.format.NumChannels == 1 {
+			sp.putSample(sp.buf[offset:], (frame[0]+frame[1])/2)
+			offset += size
+			continue
+		}
+		for _, val := range frame {
+			sp.putSample(sp.buf[offset:], val)
+			offset += size
 		}
 	}
+	return sp.buf[:offset]
+}
+
+func (sp *Speaker) putSample(buf []byte, val float32) {
+	switch sp.format.SampleFormat {
+	case Float32:
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(val))
+	case Int24:
+		valInt24 := sp.quantize(val, 1<<23-1)
+		buf[0] = byte(valInt24)
+		buf[1] = byte(valInt24 >> 8)
+		buf[2] = byte(valInt24 >> 16)
+	default:
+		valInt16 := int16(sp.quantize(val, 1<<15-1))
+		buf[0] = byte(valInt16)
+		buf[1] = byte(valInt16 >> 8)
+	}
+}
 
-	player.Write(buf)
+// quantize scales val to an integer between -max and max, adding triangular
+// dither of one step when enabled
+func (sp *Speaker) quantize(val float32, max float32) int32 {
+	scaled := float64(val) * float64(max)
+	if sp.dither {
+		scaled += sp.rng.Float64() - sp.rng.Float64()
+	}
+	scaled = math.Floor(scaled + 0.5)
+	if scaled < -float64(max) {
+		scaled = -float64(max)
+	}
+	if scaled > float64(max) {
+		scaled = float64(max)
+	}
+	return int32(scaled)
 }
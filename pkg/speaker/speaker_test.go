@@ -0,0 +1,144 @@
+package speaker
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// blockingBackend holds up every write until it is let go
+type blockingBackend struct {
+	NullBackend
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) Write(p []byte) (int, error) {
+	b.writing <- struct{}{}
+	<-b.release
+	return len(p), nil
+}
+
+func TestSpeakerUnlockedWhileWriting(t *testing.T) {
+	b := &blockingBackend{writing: make(chan struct{}), release: make(chan struct{})}
+	sp, err := New(b, Format{SampleRate: 4000, NumChannels: 2}, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.Play(&constant{1 << 20, 0.5}, nil)
+	<-b.writing
+
+	set := make(chan struct{})
+	go func() {
+		sp.SetDither(true)
+		sp.Latency()
+		close(set)
+	}()
+	select {
+	case <-set:
+	case <-time.After(time.Second):
+		t.Error("SetDither blocked while the backend was written to")
+	}
+
+	// Let writes through until the speaker has stopped
+	go func() {
+		for {
+			select {
+			case <-b.writing:
+			case b.release <- struct{}{}:
+			case <-sp.stopped:
+				return
+			}
+		}
+	}()
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpeakerCloseTwice(t *testing.T) {
+	sp, err := New(NewNullBackend(), Format{SampleRate: 4000, NumChannels: 2}, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	frames := [][2]float32{{0.5, -1}, {2, -0.25}}
+	tests := []struct {
+		format Format
+		want   []int64
+	}{
+		// Integers round to the nearest step and clip at full scale
+		{Format{48000, 2, Int16}, []int64{16384, -32767, 32767, -8192}},
+		{Format{48000, 2, Int24}, []int64{4194304, -8388607, 8388607, -2097152}},
+		{Format{48000, 2, Float32}, []int64{
+			int64(math.Float32bits(0.5)), int64(math.Float32bits(-1)), int64(math.Float32bits(2)), int64(math.Float32bits(-0.25)),
+		}},
+		// Mono mixes the sides down
+		{Format{48000, 1, Int16}, []int64{-8192, 28671}},
+	}
+	for _, test := range tests {
+		sp, err := New(NewNullBackend(), test.format, uint32(len(frames)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(sp.samples, frames)
+		data := sp.encode(len(frames))
+		sp.Close()
+
+		size := test.format.BytesPerSample()
+		if len(data) != len(test.want)*size {
+			t.Fatalf("%v: got %d bytes, want %d", test.format, len(data), len(test.want)*size)
+		}
+		for idx, want := range test.want {
+			if got := decodeSample(data[idx*size:], test.format.SampleFormat); got != want {
+				t.Errorf("%v: sample %d is %d, want %d", test.format, idx, got, want)
+			}
+		}
+	}
+}
+
+func TestDither(t *testing.T) {
+	sp, err := New(NewNullBackend(), Format{48000, 1, Int16}, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	sp.SetDither(true)
+	data := sp.encode(len(sp.samples))
+
+	// Silence dithers to a step either side of 0
+	var nonZero int
+	for idx := 0; idx < len(data); idx += 2 {
+		switch decodeSample(data[idx:], Int16) {
+		case 0:
+		case -1, 1:
+			nonZero++
+		default:
+			t.Fatalf("silence dithered to %d", decodeSample(data[idx:], Int16))
+		}
+	}
+	if nonZero == 0 {
+		t.Error("dither left silence untouched")
+	}
+}
+
+// decodeSample reads a little endian sample, giving floats as their bits
+func decodeSample(buf []byte, format SampleFormat) int64 {
+	switch format {
+	case Int16:
+		return int64(int16(binary.LittleEndian.Uint16(buf)))
+	case Int24:
+		return int64(int32(uint32(buf[0])<<8|uint32(buf[1])<<16|uint32(buf[2])<<24) >> 8)
+	default:
+		return int64(binary.LittleEndian.Uint32(buf))
+	}
+}
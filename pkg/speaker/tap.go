@@ -0,0 +1,59 @@
+package speaker
+
+import "sync"
+
+// tapSize is the number of frames a speaker keeps for Heard, enough to
+// cover its latency with room left for an analysis window
+const tapSize = 1 << 16
+
+// tap keeps the last frames streamed, mixed down to mono
+type tap struct {
+	mu      sync.Mutex
+	frames  []float32
+	written uint64
+}
+
+func (t *tap) write(samples [][2]float32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.frames == nil {
+		t.frames = make([]float32, tapSize)
+	}
+	for _, frame := range samples {
+		t.frames[t.written%tapSize] = (frame[0] + frame[1]) / 2
+		t.written++
+	}
+}
+
+// read fills dst with the frames before end, leaving silence where they
+// are older than the tap goes back, and returns how many it found
+func (t *tap) read(dst []float32, end uint64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if end > t.written {
+		end = t.written
+	}
+	oldest := uint64(0)
+	if t.written > tapSize {
+		oldest = t.written - tapSize
+	}
+
+	found := 0
+	for idx := range dst {
+		pos := end - uint64(len(dst)-idx)
+		if end < uint64(len(dst)-idx) || pos < oldest {
+			dst[idx] = 0
+			continue
+		}
+		dst[idx] = t.frames[pos%tapSize]
+		found++
+	}
+	return found
+}
+
+// Heard fills samples with the output being heard right now, mixed down to
+// mono with the newest frame last, so a display can follow the sound
+// rather than the mixer. It returns how many frames were available
+func (sp *Speaker) Heard(samples []float32) int {
+	return sp.tap.read(samples, sp.FramesPlayed())
+}
@@ -0,0 +1,78 @@
+package speaker
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	wavHeaderSize  = 44
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+)
+
+// WAVBackend writes the audio to a WAV file
+type WAVBackend struct {
+	w        io.WriteSeeker
+	format   Format
+	dataSize uint32
+}
+
+// NewWAVBackend returns a backend writing a WAV file to w. The header is
+// filled in with the final sizes on Close
+func NewWAVBackend(w io.WriteSeeker) *WAVBackend {
+	return &WAVBackend{w: w}
+}
+
+// Open writes a header for an empty file
+func (b *WAVBackend) Open(format Format, bufferSize int) error {
+	b.format = format
+	b.dataSize = 0
+	return b.writeHeader()
+}
+
+func (b *WAVBackend) Write(p []byte) (int, error) {
+	n, err := b.w.Write(p)
+	b.dataSize += uint32(n)
+	return n, err
+}
+
+// Close fixes up the header and closes the writer if it can be closed
+func (b *WAVBackend) Close() error {
+	if _, err := b.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err := b.writeHeader()
+	if closer, ok := b.w.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (b *WAVBackend) writeHeader() error {
+	if b.format.FrameSize() == 0 {
+		return errors.New("invalid wav format")
+	}
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], wavHeaderSize-8+b.dataSize)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	formatTag := uint16(wavFormatPCM)
+	if b.format.SampleFormat == Float32 {
+		formatTag = wavFormatFloat
+	}
+	binary.LittleEndian.PutUint16(header[20:], formatTag)
+	binary.LittleEndian.PutUint16(header[22:], uint16(b.format.NumChannels))
+	binary.LittleEndian.PutUint32(header[24:], b.format.SampleRate)
+	binary.LittleEndian.PutUint32(header[28:], b.format.SampleRate*uint32(b.format.FrameSize()))
+	binary.LittleEndian.PutUint16(header[32:], uint16(b.format.FrameSize()))
+	binary.LittleEndian.PutUint16(header[34:], uint16(b.format.BytesPerSample()*8))
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], b.dataSize)
+	_, err := b.w.Write(header)
+	return err
+}
@@ -0,0 +1,76 @@
+package speaker
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAVHeader(t *testing.T) {
+	tests := []struct {
+		format        Format
+		tag           uint16
+		bitsPerSample uint16
+	}{
+		{Format{48000, 2, Int16}, wavFormatPCM, 16},
+		{Format{44100, 2, Int24}, wavFormatPCM, 24},
+		{Format{22050, 1, Float32}, wavFormatFloat, 32},
+	}
+	for _, test := range tests {
+		t.Run(test.format.SampleFormat.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.wav")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := NewWAVBackend(f)
+			if err := b.Open(test.format, 4096); err != nil {
+				t.Fatal(err)
+			}
+			// Write in two blocks of 10 frames
+			block := make([]byte, 10*test.format.FrameSize())
+			for i := 0; i < 2; i++ {
+				if _, err := b.Write(block); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dataSize := uint32(2 * len(block))
+			if len(data) != wavHeaderSize+int(dataSize) {
+				t.Fatalf("got %d bytes, want %d", len(data), wavHeaderSize+dataSize)
+			}
+			le := binary.LittleEndian
+			if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+				t.Errorf("got chunk ids %q, %q and %q", data[0:4], data[8:16], data[36:40])
+			}
+			frameSize := uint32(test.format.FrameSize())
+			fields := []struct {
+				name      string
+				got, want uint32
+			}{
+				{"riff size", le.Uint32(data[4:]), uint32(len(data)) - 8},
+				{"fmt size", le.Uint32(data[16:]), 16},
+				{"format tag", uint32(le.Uint16(data[20:])), uint32(test.tag)},
+				{"channels", uint32(le.Uint16(data[22:])), uint32(test.format.NumChannels)},
+				{"sample rate", le.Uint32(data[24:]), test.format.SampleRate},
+				{"byte rate", le.Uint32(data[28:]), test.format.SampleRate * frameSize},
+				{"block align", uint32(le.Uint16(data[32:])), frameSize},
+				{"bits per sample", uint32(le.Uint16(data[34:])), uint32(test.bitsPerSample)},
+				{"data size", le.Uint32(data[40:]), dataSize},
+			}
+			for _, field := range fields {
+				if field.got != field.want {
+					t.Errorf("%s is %d, want %d", field.name, field.got, field.want)
+				}
+			}
+		})
+	}
+}
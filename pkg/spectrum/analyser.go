@@ -0,0 +1,156 @@
+// Package spectrum turns blocks of audio into levels of log spaced
+// frequency bands for a spectrum analyser display
+package spectrum
+
+import "math"
+
+// WindowSize is the number of samples Update takes
+const WindowSize = 2048
+
+const (
+	// MinFrequency and MaxFrequency are the edges of the lowest and
+	// highest bands, the top one is lowered to the Nyquist frequency
+	MinFrequency = 30
+	MaxFrequency = 16000
+	// Floor is the level of silence in dB
+	Floor = -60
+)
+
+// How the bars move between updates. Bars fall by fallRate dB, peaks stay
+// for holdUpdates and then fall by peakFallRate dB
+const (
+	fallRate     = 3
+	holdUpdates  = 30
+	peakFallRate = 1
+)
+
+// Analyser measures the level of bands of frequencies, each covering the
+// same fraction of an octave. Bars fall slowly and the peak of each band is
+// held for a while, like on a hardware analyser
+type Analyser struct {
+	sampleRate uint32
+	window     []float64
+	re, im     []float64
+	// edges holds where each band starts in FFT bins, and where the last
+	// one ends
+	edges   []float64
+	power   []float64
+	levels  []float64
+	peaks   []float64
+	peakAge []int
+}
+
+// New returns an analyser of numBands bands for audio at sampleRate
+func New(sampleRate uint32, numBands int) *Analyser {
+	a := &Analyser{
+		sampleRate: sampleRate,
+		window:     make([]float64, WindowSize),
+		re:         make([]float64, WindowSize),
+		im:         make([]float64, WindowSize),
+		power:      make([]float64, WindowSize/2+1),
+		levels:     make([]float64, numBands),
+		peaks:      make([]float64, numBands),
+		peakAge:    make([]int, numBands),
+	}
+	// A Hann window keeps loud bands from leaking into their neighbours
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(WindowSize-1))
+	}
+	for i := range a.levels {
+		a.levels[i] = Floor
+		a.peaks[i] = Floor
+	}
+
+	a.edges = make([]float64, numBands+1)
+	for i := range a.edges {
+		a.edges[i] = a.BandFrequency(i) * WindowSize / float64(sampleRate)
+	}
+	return a
+}
+
+// NumBands returns the number of bands the analyser was made with
+func (a *Analyser) NumBands() int {
+	return len(a.levels)
+}
+
+// BandFrequency returns the frequency in Hz at the lower edge of band i.
+// i may be NumBands for the upper edge of the last band
+func (a *Analyser) BandFrequency(i int) float64 {
+	top := math.Min(MaxFrequency, float64(a.sampleRate)/2)
+	return MinFrequency * math.Pow(top/MinFrequency, float64(i)/float64(len(a.levels)))
+}
+
+// bandPower returns the power of the loudest bin in band. Low bands can
+// be narrower than a bin, those are read between the bins around them
+func (a *Analyser) bandPower(band int) float64 {
+	first := int(math.Ceil(a.edges[band]))
+	last := int(math.Floor(a.edges[band+1]))
+	if last > WindowSize/2 {
+		last = WindowSize / 2
+	}
+	if first > last {
+		centre := (a.edges[band] + a.edges[band+1]) / 2
+		bin := int(centre)
+		if bin >= WindowSize/2 {
+			return a.power[WindowSize/2]
+		}
+		frac := centre - float64(bin)
+		return a.power[bin]*(1-frac) + a.power[bin+1]*frac
+	}
+
+	var power float64
+	for bin := first; bin <= last; bin++ {
+		power = math.Max(power, a.power[bin])
+	}
+	return power
+}
+
+// Update analyses the last WindowSize samples, oldest first, and moves the
+// bars and peaks towards them
+func (a *Analyser) Update(samples []float32) {
+	for i := range a.re {
+		value := 0.0
+		if i < len(samples) {
+			value = float64(samples[i])
+		}
+		a.re[i] = value * a.window[i]
+		a.im[i] = 0
+	}
+	fft(a.re, a.im)
+	for bin := range a.power {
+		a.power[bin] = a.re[bin]*a.re[bin] + a.im[bin]*a.im[bin]
+	}
+
+	for band := range a.levels {
+		power := a.bandPower(band)
+		// A full scale sine comes out of the window at a quarter of the
+		// window's length
+		level := float64(Floor)
+		if power > 0 {
+			level = math.Max(10*math.Log10(power)-20*math.Log10(WindowSize/4), Floor)
+		}
+
+		if level < a.levels[band]-fallRate {
+			level = a.levels[band] - fallRate
+		}
+		a.levels[band] = level
+
+		if level >= a.peaks[band] {
+			a.peaks[band] = level
+			a.peakAge[band] = 0
+		} else if a.peakAge[band]++; a.peakAge[band] > holdUpdates {
+			a.peaks[band] = math.Max(a.peaks[band]-peakFallRate, level)
+		}
+	}
+}
+
+// Levels returns the level of each band in dB, from Floor up to about 0
+// for a full scale sine
+func (a *Analyser) Levels() []float64 {
+	return a.levels
+}
+
+// Peaks returns the held peak level of each band in dB
+func (a *Analyser) Peaks() []float64 {
+	return a.peaks
+}
@@ -0,0 +1,101 @@
+package spectrum
+
+import (
+	"math"
+	"testing"
+)
+
+// sine returns a window of a full scale sine at frequency
+func sine(frequency float64, sampleRate uint32) []float32 {
+	samples := make([]float32, WindowSize)
+	for i := range samples {
+		samples[i] = float32(math.Sin(2 * math.Pi * frequency * float64(i) / float64(sampleRate)))
+	}
+	return samples
+}
+
+func TestBandFrequency(t *testing.T) {
+	tests := []struct {
+		sampleRate uint32
+		numBands   int
+		band       int
+		want       float64
+	}{
+		{48000, 32, 0, MinFrequency},
+		{48000, 32, 32, MaxFrequency},
+		{22050, 32, 32, 11025},
+		{48000, 2, 1, math.Sqrt(MinFrequency * MaxFrequency)},
+	}
+	for _, test := range tests {
+		a := New(test.sampleRate, test.numBands)
+		if got := a.BandFrequency(test.band); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("band %d of %d at %d Hz starts at %v, want %v", test.band, test.numBands, test.sampleRate, got, test.want)
+		}
+	}
+}
+
+func TestSinePeaksInItsBand(t *testing.T) {
+	// The tones sit on the centres of FFT bins, as a band can't tell
+	// apart tones closer together than a bin
+	tests := []struct {
+		bin        int
+		sampleRate uint32
+	}{
+		{2, 48000},
+		{5, 48000},
+		{43, 48000},
+		{232, 44100},
+		{512, 48000},
+	}
+	for _, test := range tests {
+		frequency := float64(test.bin) * float64(test.sampleRate) / WindowSize
+		a := New(test.sampleRate, 32)
+		a.Update(sine(frequency, test.sampleRate))
+		loudest := 0
+		for band, level := range a.Levels() {
+			if level > a.Levels()[loudest] {
+				loudest = band
+			}
+		}
+		if frequency < a.BandFrequency(loudest) || frequency >= a.BandFrequency(loudest+1) {
+			t.Errorf("%v Hz peaks in band %d, %v to %v Hz", frequency, loudest,
+				a.BandFrequency(loudest), a.BandFrequency(loudest+1))
+		}
+		// A full scale sine reads about 0 dB
+		if level := a.Levels()[loudest]; level < -3 || level > 1 {
+			t.Errorf("%v Hz reads %.1f dB", frequency, level)
+		}
+	}
+}
+
+func TestFall(t *testing.T) {
+	a := New(48000, 16)
+	for _, level := range a.Levels() {
+		if level != Floor {
+			t.Fatalf("new analyser reads %v dB, want the floor", level)
+		}
+	}
+
+	a.Update(sine(1000, 48000))
+	loud := append([]float64(nil), a.Levels()...)
+	silence := make([]float32, WindowSize)
+	for update := 1; update <= holdUpdates; update++ {
+		a.Update(silence)
+		for band, level := range a.Levels() {
+			want := math.Max(loud[band]-fallRate*float64(update), Floor)
+			if math.Abs(level-want) > 1e-9 {
+				t.Fatalf("update %d: band %d reads %v dB, want %v", update, band, level, want)
+			}
+			// Peaks hold while the bars fall
+			if peak := a.Peaks()[band]; peak != loud[band] {
+				t.Fatalf("update %d: band %d peaks at %v dB, want %v", update, band, peak, loud[band])
+			}
+		}
+	}
+	a.Update(silence)
+	for band, peak := range a.Peaks() {
+		if loud[band] > Floor+20 && peak != loud[band]-peakFallRate {
+			t.Errorf("band %d peaks at %v dB after the hold, want %v", band, peak, loud[band]-peakFallRate)
+		}
+	}
+}
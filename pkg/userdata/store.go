@@ -0,0 +1,181 @@
+package userdata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxRating is the highest star rating
+const MaxRating = 5
+
+// Record is what the user has told us about a module and how often they
+// listened to it
+type Record struct {
+	Favourite bool `json:"favourite,omitempty"`
+	// Rating is 1 to MaxRating stars, 0 when unrated
+	Rating     int       `json:"rating,omitempty"`
+	PlayCount  int       `json:"playCount,omitempty"`
+	LastPlayed time.Time `json:"lastPlayed"`
+}
+
+// Store keeps favourites, ratings and play history by module path, saving
+// after every change. It is safe to use from several goroutines
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+// DefaultPath returns where the store lives in the user's config
+// directory, or "" when there is none
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gomodplay", "userdata.json")
+}
+
+// Open reads the store at path, starting empty when it doesn't exist yet.
+// A store with an empty path lives in memory only
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		records: make(map[string]*Record),
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the record of a module, empty if nothing is known about it
+func (s *Store) Get(path string) Record {
+	path = absPath(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[path]; ok {
+		return *record
+	}
+	return Record{}
+}
+
+// SetFavourite marks or unmarks a module as a favourite
+func (s *Store) SetFavourite(path string, favourite bool) error {
+	return s.update(path, func(record *Record) {
+		record.Favourite = favourite
+	})
+}
+
+// SetRating sets the star rating of a module, 0 to clear it
+func (s *Store) SetRating(path string, rating int) error {
+	if rating < 0 {
+		rating = 0
+	}
+	if rating > MaxRating {
+		rating = MaxRating
+	}
+	return s.update(path, func(record *Record) {
+		record.Rating = rating
+	})
+}
+
+// RecordPlay counts a play of a module that finished at the given time
+func (s *Store) RecordPlay(path string, at time.Time) error {
+	return s.update(path, func(record *Record) {
+		record.PlayCount++
+		record.LastPlayed = at
+	})
+}
+
+// Favourites returns the paths of the favourite modules, sorted
+func (s *Store) Favourites() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for path, record := range s.records {
+		if record.Favourite {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Recent returns the paths of the modules played most recently, newest
+// first, at most limit of them
+func (s *Store) Recent(limit int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for path, record := range s.records {
+		if record.PlayCount > 0 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return s.records[paths[i]].LastPlayed.After(s.records[paths[j]].LastPlayed)
+	})
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths
+}
+
+func (s *Store) update(path string, change func(record *Record)) error {
+	path = absPath(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[path]
+	if !ok {
+		record = &Record{}
+		s.records[path] = record
+	}
+	change(record)
+	if *record == (Record{}) {
+		delete(s.records, path)
+	}
+	return s.save()
+}
+
+// save writes the store through a temporary file, so a crash never leaves
+// half of it behind
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.records, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
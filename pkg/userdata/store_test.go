@@ -0,0 +1,124 @@
+package userdata
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetRating(t *testing.T) {
+	tests := []struct {
+		rating int
+		want   int
+	}{
+		{0, 0},
+		{3, 3},
+		{MaxRating, MaxRating},
+		{MaxRating + 2, MaxRating},
+		{-1, 0},
+	}
+	for _, test := range tests {
+		s, _ := Open("")
+		if err := s.SetRating("song.mod", test.rating); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Get("song.mod").Rating; got != test.want {
+			t.Errorf("SetRating(%d) gave rating %d, want %d", test.rating, got, test.want)
+		}
+	}
+}
+
+func TestFavourites(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a.mod"), filepath.Join(dir, "b.mod"), filepath.Join(dir, "c.mod")
+	tests := []struct {
+		name   string
+		change func(s *Store)
+		want   []string
+	}{
+		{"none", func(s *Store) {}, nil},
+		{"sorted", func(s *Store) { s.SetFavourite(c, true); s.SetFavourite(a, true) }, []string{a, c}},
+		{"unmarked", func(s *Store) { s.SetFavourite(a, true); s.SetFavourite(b, true); s.SetFavourite(a, false) }, []string{b}},
+		{"rated only", func(s *Store) { s.SetRating(a, 4) }, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := Open("")
+			test.change(s)
+			if got := s.Favourites(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecent(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a.mod"), filepath.Join(dir, "b.mod"), filepath.Join(dir, "c.mod")
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, _ := Open("")
+	s.RecordPlay(b, start)
+	s.RecordPlay(a, start.Add(time.Minute))
+	s.RecordPlay(c, start.Add(2*time.Minute))
+	s.RecordPlay(b, start.Add(3*time.Minute))
+	s.SetFavourite(filepath.Join(dir, "unplayed.mod"), true)
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{0, []string{}},
+		{2, []string{b, c}},
+		{10, []string{b, c, a}},
+	}
+	for _, test := range tests {
+		if got := s.Recent(test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Recent(%d) = %q, want %q", test.limit, got, test.want)
+		}
+	}
+	if got := s.Get(b); got.PlayCount != 2 || !got.LastPlayed.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("got %d plays, last at %v", got.PlayCount, got.LastPlayed)
+	}
+}
+
+func TestOpenSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "userdata.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	played := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, err := range []error{
+		s.SetFavourite("a.mod", true),
+		s.SetRating("a.mod", 4),
+		s.RecordPlay("b.mod", played),
+		s.SetRating("c.mod", 2),
+		s.SetRating("c.mod", 0),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want Record
+	}{
+		{"a.mod", Record{Favourite: true, Rating: 4}},
+		{"b.mod", Record{PlayCount: 1, LastPlayed: played}},
+		{"c.mod", Record{}},
+	}
+	for _, test := range tests {
+		if got := reopened.Get(test.path); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.path, got, test.want)
+		}
+	}
+	if len(reopened.records) != 2 {
+		t.Errorf("got %d records, want the cleared one dropped", len(reopened.records))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/zeozeozeo/gomodplay/pkg/mod"
+)
+
+var scopeColour = tcell.GetColor("#50FA7B")
+
+// brailleDots maps a dot's column and row in a braille character to its bit
+var brailleDots = [2][4]rune{
+	{0x01, 0x02, 0x04, 0x40},
+	{0x08, 0x10, 0x20, 0x80},
+}
+
+// brailleCanvas is a grid of braille characters, each 2 dots wide and 4 high
+type brailleCanvas struct {
+	width, height int
+	cells         []rune
+}
+
+func newBrailleCanvas(width, height int) *brailleCanvas {
+	return &brailleCanvas{
+		width:  width,
+		height: height,
+		cells:  make([]rune, width*height),
+	}
+}
+
+func (c *brailleCanvas) set(x, y int) {
+	if x < 0 || y < 0 || x >= c.width*2 || y >= c.height*4 {
+		return
+	}
+	c.cells[y/4*c.width+x/2] |= brailleDots[x%2][y%4]
+}
+
+// line sets the dots in column x from y1 to y2
+func (c *brailleCanvas) line(x, y1, y2 int) {
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	for y := y1; y <= y2; y++ {
+		c.set(x, y)
+	}
+}
+
+func (c *brailleCanvas) draw(s tcell.Screen, x, y int, style tcell.Style) {
+	c.drawColumns(s, x, y, func(int) tcell.Style {
+		return style
+	})
+}
+
+// drawColumns draws the canvas with each column of cells in its own style
+func (c *brailleCanvas) drawColumns(s tcell.Screen, x, y int, styleAt func(col int) tcell.Style) {
+	for col := 0; col < c.width; col++ {
+		style := styleAt(col)
+		for row := 0; row < c.height; row++ {
+			s.SetContent(x+col, y+row, 0x2800+c.cells[row*c.width+col], nil, style)
+		}
+	}
+}
+
+// scopeMinScale is the quietest peak a scope is scaled up to fill, so a
+// channel that is close to silent doesn't show its noise full size
+const scopeMinScale = 1.0 / 64
+
+// drawScope draws the samples as a waveform filling width by height cells,
+// scaled to their peak and joining the points so fast waves don't break up
+// into dots
+func drawScope(s tcell.Screen, x, y, width, height int, samples []float32, style tcell.Style) {
+	if len(samples) == 0 {
+		return
+	}
+	var scale float32 = scopeMinScale
+	for _, value := range samples {
+		if value > scale {
+			scale = value
+		} else if -value > scale {
+			scale = -value
+		}
+	}
+
+	canvas := newBrailleCanvas(width, height)
+	dotsX, dotsY := width*2, height*4
+	prevY := -1
+	for dotX := 0; dotX < dotsX; dotX++ {
+		value := samples[dotX*len(samples)/dotsX] / scale
+		dotY := int((1 - value) / 2 * float32(dotsY-1))
+		if prevY < 0 {
+			prevY = dotY
+		}
+		canvas.line(dotX, prevY, dotY)
+		prevY = dotY
+	}
+	canvas.draw(s, x, y, style)
+}
+
+// drawScopes draws an oscilloscope of each channel in b, lined up with the
+// channel's column in a pattern view of the same width
+func drawScopes(s tcell.Screen, snapshot mod.Snapshot, b box, scroll int) {
+	drawBox(s, b.x1, b.y1, b.x2, b.y2)
+	xPos, yPos := b.x1+1, b.y1+1
+	height := b.innerHeight()
+
+	defaultStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(sampleFgColour)
+	drawText(s, xPos, yPos, rowNumberWidth, 1, defaultStyle, "Scope")
+
+	numChannels := len(snapshot.Channels)
+	column, visible := pickPatternColumns(b.innerWidth(), numChannels)
+	for idx := scroll; idx < scroll+visible && idx < numChannels; idx++ {
+		x := xPos + rowNumberWidth + (idx-scroll)*column.width
+		for row := 0; row < height; row++ {
+			drawText(s, x, yPos+row, 1, 1, defaultStyle, "│")
+		}
+		channel := snapshot.Channels[idx]
+		style := defaultStyle.Foreground(scopeColour)
+		if !channel.Audible {
+			style = defaultStyle
+		}
+		drawScope(s, x+1, yPos, column.width-1, height, channel.Scope, style)
+	}
+}
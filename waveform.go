@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/zeozeozeo/gomodplay/pkg/mod"
+)
+
+// channelMark returns the character a channel is marked with, its number
+// up to 9 and then letters
+func channelMark(channelNum int) rune {
+	if channelNum < 9 {
+		return rune('1' + channelNum)
+	}
+	return rune('a' + channelNum - 9)
+}
+
+// drawWaveform draws sample number sampleIdx in b under a line of details
+// about it. The loop is drawn in another colour and marked on the bottom
+// border, and the channels playing the sample are marked on the top border
+// where they are in it
+func drawWaveform(s tcell.Screen, snapshot mod.Snapshot, b box, sampleIdx int) {
+	drawBox(s, b.x1, b.y1, b.x2, b.y2)
+	xPos, yPos := b.x1+1, b.y1+1
+	width := b.innerWidth()
+
+	defaultStyle := tcell.StyleDefault.Background(backgroundColour).Foreground(sampleFgColour)
+	waveStyle := defaultStyle.Foreground(scopeColour)
+	loopStyle := defaultStyle.Foreground(effectColour)
+	borderStyle := tcell.StyleDefault.Background(boxBgColour).Foreground(boxFgColour)
+
+	if sampleIdx < 0 || sampleIdx >= len(snapshot.Song.Samples) {
+		return
+	}
+	sample := snapshot.Song.Samples[sampleIdx]
+	data := sample.Data()
+	if uint32(len(data)) > sample.Size() {
+		data = data[:sample.Size()]
+	}
+	loopStart, loopLength := sample.Loop()
+	looped := loopLength > 2
+
+	loop := "none"
+	if looped {
+		loop = fmt.Sprintf("%d-%d", loopStart, loopStart+loopLength)
+	}
+	details := fmt.Sprintf("%02d %s  Size: %d  Loop: %s  Finetune: %+d  Volume: %d",
+		sampleIdx+1, strings.TrimRight(sample.Name, "\x00 "), sample.Size(), loop, sample.FineTune(), sample.Volume())
+	drawText(s, xPos, yPos, width-1, 1, songStyle, details)
+	yPos++
+
+	if len(data) == 0 {
+		drawText(s, xPos, yPos, width-1, 1, defaultStyle, "Empty sample")
+		return
+	}
+
+	// column returns the cell a byte of the sample is drawn in
+	column := func(pos int) int {
+		col := pos * width / len(data)
+		if col >= width {
+			col = width - 1
+		}
+		return col
+	}
+
+	rows := b.innerHeight() - 1
+	canvas := newBrailleCanvas(width, rows)
+	dotsX, dotsY := width*2, rows*4
+	dotY := func(value int8) int {
+		return (127 - int(value)) * (dotsY - 1) / 255
+	}
+	for dotX := 0; dotX < dotsX; dotX++ {
+		start := dotX * len(data) / dotsX
+		end := (dotX + 1) * len(data) / dotsX
+		if end <= start {
+			end = start + 1
+		}
+		low, high := data[start], data[start]
+		for _, value := range data[start:end] {
+			if value < low {
+				low = value
+			}
+			if value > high {
+				high = value
+			}
+		}
+		canvas.line(dotX, dotY(high), dotY(low))
+	}
+
+	loopFirst, loopLast := -1, -1
+	if looped {
+		loopFirst = column(int(loopStart))
+		loopLast = column(int(loopStart+loopLength) - 1)
+	}
+	canvas.drawColumns(s, xPos, yPos, func(col int) tcell.Style {
+		if col >= loopFirst && col <= loopLast {
+			return loopStyle
+		}
+		return waveStyle
+	})
+	if looped {
+		s.SetContent(xPos+loopFirst, b.y2, '[', nil, borderStyle.Foreground(effectColour))
+		s.SetContent(xPos+loopLast, b.y2, ']', nil, borderStyle.Foreground(effectColour))
+	}
+
+	for idx, channel := range snapshot.Channels {
+		if !channel.Playing || int(channel.SampleNum) != sampleIdx+1 {
+			continue
+		}
+		s.SetContent(xPos+column(int(channel.SamplePosition)), b.y1, channelMark(idx), nil, borderStyle.Foreground(songColour).Bold(true))
+	}
+}